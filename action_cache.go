@@ -16,15 +16,24 @@ func newActionCache(endpoint EndpointStruct, config map[string]interface{}) Acti
 		__action__ = "cache"
 		doPanic = makeActionExecutionPanicFn(endpoint, __action__)
 		configMap  = PathAccessor{config: config}
-		mapping = configMap.Get("mapping", make(map[string]string)).(map[string]string)
+		mapping = configMap.Get("mapping", make(map[string]any)).(map[string]any)
 		cacheTimeout = time.Duration(configMap.Get("timeout", 5*60).(int)) * time.Second
-		allowedMethods = map[string]any{"POST": 1, "PUT": 1}
+		namespace = configMap.Get("namespace", "").(string)
+		allowedMethods = map[string]any{"POST": 1, "PUT": 1, "PATCH": 1, "DELETE": 1}
+		cacheKeys = make(map[string]string, len(mapping))
 	)
 
-	if _, contains := allowedMethods[endpoint.Method]; !contains {
+	if !endpoint.Method.AnyOf(allowedMethods) {
 		actionSetupPanic(endpoint, __action__,
 			"Invalid endpoint method to use this action")
 	}
+	for path, cacheKey := range mapping {
+		keyTpl, ok := cacheKey.(string)
+		if !ok {
+			actionSetupPanic(endpoint, __action__, "Invalid cache key %v for '%s', expected a string", cacheKey, path)
+		}
+		cacheKeys[path] = keyTpl
+	}
 
 	return func(
 		requestId string,
@@ -34,7 +43,8 @@ func newActionCache(endpoint EndpointStruct, config map[string]interface{}) Acti
 		context map[string]interface{},
 	) {
 		contextAccessor := &PathAccessor{context}
-		for path, cacheKey := range mapping {
+		resolvedNamespace := contextNamespace(namespace, context)
+		for path, cacheKey := range cacheKeys {
 			path = fromTemplate(path, context)
 			if value, err := contextAccessor.Must(path); err != nil {
 				doPanic(
@@ -43,7 +53,7 @@ func newActionCache(endpoint EndpointStruct, config map[string]interface{}) Acti
 					path)
 			} else {
 				globalContext.Add(
-					fromTemplate(cacheKey, context),
+					namespacedKey(resolvedNamespace, fromTemplate(cacheKey, context)),
 					value,
 					cacheTimeout)
 			}
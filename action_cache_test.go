@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// clearNamespace removes all keys of namespace from the global context.
+func clearNamespace(namespace string) {
+	for key := range globalContext.ToMap() {
+		if _, ok := inNamespace(namespace, key); ok {
+			globalContext.Delete(key)
+		}
+	}
+}
+
+const testCacheActionsConfig = `
+endpoints:
+  - url: /counter/:key/:by
+    method: POST
+    namespace: test-cache-actions
+    actions:
+      - type: cache-increment
+        params: {key: '{{.params.key}}', by: '{{.params.by}}', contextTarget: count}
+      - type: response
+        params: {body: '{{.count}}'}
+  - url: /queue/:item
+    method: POST
+    namespace: test-cache-actions
+    actions:
+      - type: cache-append
+        params: {key: queue, value: '{{.params.item}}', contextTarget: length}
+      - type: response
+        params: {body: '{{.length}}'}
+  - url: /queue/front
+    method: DELETE
+    namespace: test-cache-actions
+    actions:
+      - type: cache-pop
+        params: {key: queue, default: empty}
+      - type: response
+        params: {body: '{{.item}}'}
+  - url: /queue/back
+    method: DELETE
+    namespace: test-cache-actions
+    actions:
+      - type: cache-pop
+        params: {key: queue, from: back, default: empty}
+      - type: response
+        params: {body: '{{.item}}'}
+  - url: /lock/:owner
+    method: POST
+    namespace: test-cache-actions
+    actions:
+      - type: cache-set-if
+        params: {key: lock, value: '{{.params.owner}}', absent: true, contextTarget: acquired}
+      - type: response
+        params: {body: '{{.acquired}}'}
+  - url: /lock/:owner
+    method: DELETE
+    namespace: test-cache-actions
+    actions:
+      - type: cache-set-if
+        params: {key: lock, value: free, equals: '{{.params.owner}}', required: true}
+      - type: response
+        params: {body: released}
+  - url: /reset
+    method: DELETE
+    namespace: test-cache-actions
+    actions:
+      - type: cache-delete
+        params: {keys: [a, b, missing], contextTarget: deleted}
+      - type: response
+        params: {body: '{{.deleted}}'}
+`
+
+func TestCacheIncrementConcurrent(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testCacheActionsConfig})
+	defer clearNamespace("test-cache-actions")
+
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if status, body := serveTest(server, http.MethodPost, "/counter/hits/1", "", nil); status != http.StatusOK {
+				t.Errorf("got %d %s", status, body)
+			}
+		}()
+	}
+	wait.Wait()
+	if _, body := serveTest(server, http.MethodPost, "/counter/hits/2.5", "", nil); body != "52.5" {
+		t.Errorf("got %q after 50 concurrent increments, want 52.5", body)
+	}
+
+	globalContext.Add(namespacedKey("test-cache-actions", "text"), "abc", 0)
+	if status, _ := serveTest(server, http.MethodPost, "/counter/text/1", "", nil); status != http.StatusInternalServerError {
+		t.Errorf("incrementing text: got %d, want 500", status)
+	}
+}
+
+func TestCacheAppendAndPop(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testCacheActionsConfig})
+	defer clearNamespace("test-cache-actions")
+
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			serveTest(server, http.MethodPost, fmt.Sprintf("/queue/%d", i), "", nil)
+		}(i)
+	}
+	wait.Wait()
+	if _, body := serveTest(server, http.MethodPost, "/queue/last", "", nil); body != "21" {
+		t.Errorf("got length %q after 20 concurrent appends, want 21", body)
+	}
+
+	if _, body := serveTest(server, http.MethodDelete, "/queue/back", "", nil); body != "last" {
+		t.Errorf("popped %q from the back, want last", body)
+	}
+	popped := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		_, body := serveTest(server, http.MethodDelete, "/queue/front", "", nil)
+		popped[body] = true
+	}
+	if len(popped) != 20 || popped["empty"] {
+		t.Errorf("popped %v, want each appended item once", popped)
+	}
+	if _, body := serveTest(server, http.MethodDelete, "/queue/front", "", nil); body != "empty" {
+		t.Errorf("popped %q from an empty list, want the default", body)
+	}
+}
+
+func TestCacheSetIf(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testCacheActionsConfig})
+	defer clearNamespace("test-cache-actions")
+
+	results := make(chan string, 10)
+	var wait sync.WaitGroup
+	for i := 0; i < cap(results); i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			_, body := serveTest(server, http.MethodPost, fmt.Sprintf("/lock/owner-%d", i), "", nil)
+			results <- body
+		}(i)
+	}
+	wait.Wait()
+	close(results)
+	acquired := 0
+	for result := range results {
+		if result == "true" {
+			acquired++
+		}
+	}
+	if acquired != 1 {
+		t.Fatalf("lock acquired %d times, want once", acquired)
+	}
+
+	owner := globalContext.Get(namespacedKey("test-cache-actions", "lock"), nil)
+	if status, _ := serveTest(server, http.MethodDelete, "/lock/someone-else", "", nil); status != http.StatusInternalServerError {
+		t.Errorf("release by another owner: got %d, want 500", status)
+	}
+	if status, body := serveTest(server, http.MethodDelete, fmt.Sprintf("/lock/%v", owner), "", nil); body != "released" {
+		t.Errorf("release by the owner: got %d %s", status, body)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testCacheActionsConfig})
+	defer clearNamespace("test-cache-actions")
+
+	globalContext.Add(namespacedKey("test-cache-actions", "a"), 1, 0)
+	globalContext.Add(namespacedKey("test-cache-actions", "b"), 2, 0)
+	globalContext.Add("a", "default namespace", 0)
+	defer globalContext.Delete("a")
+
+	if _, body := serveTest(server, http.MethodDelete, "/reset", "", nil); body != "2" {
+		t.Errorf("deleted %s keys, want 2", body)
+	}
+	if _, body := serveTest(server, http.MethodDelete, "/reset", "", nil); body != "0" {
+		t.Errorf("deleted %s keys again, want 0", body)
+	}
+	if globalContext.Get("a", nil) != "default namespace" {
+		t.Error("deleted a key of another namespace")
+	}
+}
+
+func TestCacheDeleteRejectsInvalidKeys(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /reset
+    method: DELETE
+    actions:
+      - type: cache-delete
+        params: {keys: [a, {nested: key}]}
+`})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDummyServer(&cfg.Servers[0]); err == nil || !strings.Contains(err.Error(), "Invalid key") {
+		t.Errorf("got error %v, want an invalid key", err)
+	}
+}
+
+func TestCacheRejectsInvalidKeys(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /store
+    method: POST
+    actions:
+      - type: cache
+        params: {mapping: {params.id: 42}}
+`})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDummyServer(&cfg.Servers[0]); err == nil || !strings.Contains(err.Error(), "Invalid cache key 42") {
+		t.Errorf("got error %v, want an invalid cache key", err)
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /user/:id
+    method: GET
+    namespace: '{{index .__headers__ "X-Test-Id"}}'
+    actions:
+      - type: cache-get
+        params:
+          mapping:
+            'user.{{.params.id}}': user
+            theme: {path: settings.theme, default: light}
+            missing: {path: settings.missing, default: {nested: [1]}}
+      - type: response
+        params: {body: '{{.user}} {{.settings.theme}} {{index .settings.missing.nested 0}}'}
+  - url: /shared
+    method: GET
+    namespace: test-cache-get-a
+    actions:
+      - type: cache-get
+        params: {mapping: {theme: theme}, namespace: test-cache-get-b}
+      - type: response
+        params: {body: '{{.theme}}'}
+`})
+	defer clearNamespace("test-cache-get-a")
+	defer clearNamespace("test-cache-get-b")
+	globalContext.Add(namespacedKey("test-cache-get-a", "user.1"), "ada", 0)
+	globalContext.Add(namespacedKey("test-cache-get-b", "user.1"), "bob", 0)
+	globalContext.Add(namespacedKey("test-cache-get-b", "theme"), "dark", 0)
+
+	tests := []struct {
+		name      string
+		url       string
+		namespace string
+		want      string
+	}{
+		{"templated key and defaults", "/user/1", "test-cache-get-a", "200 ada light 1"},
+		{"namespace of the request", "/user/1", "test-cache-get-b", "200 bob dark 1"},
+		{"missing key without default", "/user/2", "test-cache-get-a", "500 "},
+		{"configured namespace", "/shared", "", "200 dark"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := serveTest(server, http.MethodGet, test.url, "", map[string]string{"X-Test-Id": test.namespace})
+			if got := fmt.Sprintf("%d %s", status, body); !strings.HasPrefix(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCacheGetRejectsInvalidMappings(t *testing.T) {
+	for _, mapping := range []string{"{key: 42}", "{key: {default: 1}}"} {
+		dir := writeTestFiles(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /get
+    method: GET
+    actions:
+      - type: cache-get
+        params: {mapping: ` + mapping + `}
+`})
+		cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newDummyServer(&cfg.Servers[0]); err == nil || !strings.Contains(err.Error(), "'key'") {
+			t.Errorf("%s: got error %v, want an invalid mapping", mapping, err)
+		}
+	}
+}
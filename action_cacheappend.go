@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-append"] = newActionCacheAppend
+}
+
+func newActionCacheAppend(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "cache-append"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		key           = configMap.Get("key", "").(string)
+		value         = configMap.Get("value", nil)
+		path          = configMap.Get("path", "").(string)
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		cacheTimeout  = time.Duration(configMap.Get("timeout", 5*60).(int)) * time.Second
+	)
+
+	if key == "" {
+		actionSetupPanic(endpoint, __action__, "Must specify a key")
+	}
+	if (value == nil) == (path == "") {
+		actionSetupPanic(endpoint, __action__, "Must specify exactly one of value or path")
+	}
+	log.Printf("| {action:cache-append=%s}", key)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		item, err := resolveCacheValue(path, value, context)
+		if err != nil {
+			doPanic(requestId, "failed to resolve context path '%s': %v", path, err)
+		}
+		resolvedKey := fromTemplate(key, context)
+		var length int
+		if !globalContext.Update(namespacedKey(contextNamespace(namespace, context), resolvedKey), cacheTimeout,
+			func(current any, exists bool) (any, bool) {
+				list, isList := current.([]any)
+				if exists && !isList {
+					return nil, false
+				}
+				// copy, snapshots of the cache may still reference the old list
+				updated := make([]any, len(list), len(list)+1)
+				copy(updated, list)
+				updated = append(updated, item)
+				length = len(updated)
+				return updated, true
+			}) {
+			doPanic(requestId, "cache key '%s' is not a list", resolvedKey)
+		}
+		if contextTarget != "" {
+			(&PathAccessor{context}).Set(contextTarget, length)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-delete"] = newActionCacheDelete
+}
+
+func newActionCacheDelete(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "cache-delete"
+		configMap     = PathAccessor{config: config}
+		keyList       = configMap.Get("keys", []interface{}{}).([]interface{})
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		keys          = make([]string, 0, len(keyList))
+	)
+
+	if len(keyList) == 0 {
+		actionSetupPanic(endpoint, __action__, "Must specify at least one key")
+	}
+	for _, key := range keyList {
+		keyTpl, ok := key.(string)
+		if !ok {
+			actionSetupPanic(endpoint, __action__, "Invalid key %v, expected a string", key)
+		}
+		keys = append(keys, keyTpl)
+	}
+	log.Printf("| {action:cache-delete=%v}", keys)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		resolvedNamespace := contextNamespace(namespace, context)
+		deleted := 0
+		for _, keyTpl := range keys {
+			if globalContext.Delete(namespacedKey(resolvedNamespace, fromTemplate(keyTpl, context))) {
+				deleted++
+			}
+		}
+		if contextTarget != "" {
+			(&PathAccessor{context}).Set(contextTarget, deleted)
+		}
+	}
+}
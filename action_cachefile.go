@@ -20,10 +20,10 @@ func newActionCacheFile(endpoint EndpointStruct, config map[string]interface{})
 		configMap  = PathAccessor{config: config}
 		mapping = configMap.Get("mapping", make(map[string]any)).(map[string]any)
 		cacheTimeout = time.Duration(configMap.Get("timeout", 5*60).(int)) * time.Second
-		allowedMethods = map[string]any{"POST": 1, "PUT": 1}
+		allowedMethods = map[string]any{"POST": 1, "PUT": 1, "PATCH": 1, "DELETE": 1}
 	)
 
-	if _, contains := allowedMethods[endpoint.Method]; !contains {
+	if !endpoint.Method.AnyOf(allowedMethods) {
 		actionSetupPanic(endpoint, __action__,
 			"Invalid endpoint method to use this action")
 	}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-get"] = newActionCacheGet
+}
+
+func newActionCacheGet(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__ = "cache-get"
+		doPanic    = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap  = PathAccessor{config: config}
+		mapping    = configMap.Get("mapping", make(map[string]any)).(map[string]any)
+		namespace  = configMap.Get("namespace", "").(string)
+	)
+
+	type target struct {
+		path         string
+		defaultValue any
+		hasDefault   bool
+	}
+	targets := make(map[string]target, len(mapping))
+	for cacheKey, value := range mapping {
+		switch value := value.(type) {
+		case string:
+			targets[cacheKey] = target{path: value}
+		case map[string]any:
+			path, _ := value["path"].(string)
+			defaultValue, hasDefault := value["default"]
+			targets[cacheKey] = target{path, defaultValue, hasDefault}
+		default:
+			actionSetupPanic(endpoint, __action__,
+				"Invalid mapping for '%s', expected a context path or {path, default}", cacheKey)
+		}
+		if targets[cacheKey].path == "" {
+			actionSetupPanic(endpoint, __action__, "Missing context path for '%s'", cacheKey)
+		}
+	}
+	log.Printf("| {action:cache-get=%v}", mapping)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		contextAccessor := &PathAccessor{context}
+		resolvedNamespace := contextNamespace(namespace, context)
+		for cacheKey, target := range targets {
+			cacheKey = fromTemplate(cacheKey, context)
+			value := globalContext.Get(namespacedKey(resolvedNamespace, cacheKey), nil)
+			if value == nil {
+				if !target.hasDefault {
+					doPanic(requestId, "cache key '%s' not found", cacheKey)
+				}
+				value = target.defaultValue
+			}
+			contextAccessor.Set(fromTemplate(target.path, context), value)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-increment"] = newActionCacheIncrement
+}
+
+func newActionCacheIncrement(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "cache-increment"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		key           = configMap.Get("key", "").(string)
+		by            = configMap.Get("by", 1)
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		cacheTimeout  = time.Duration(configMap.Get("timeout", 5*60).(int)) * time.Second
+	)
+
+	if key == "" {
+		actionSetupPanic(endpoint, __action__, "Must specify a key")
+	}
+	if _, err := cacheNumber(by); err != nil {
+		if _, isTemplate := by.(string); !isTemplate {
+			actionSetupPanic(endpoint, __action__, "Invalid increment: %v", err)
+		}
+	}
+	log.Printf("| {action:cache-increment=%s+%v}", key, by)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		var increment any = by
+		if template, ok := by.(string); ok {
+			increment = fromTemplate(template, context)
+		}
+		delta, err := cacheNumber(increment)
+		if err != nil {
+			doPanic(requestId, "invalid increment: %v", err)
+		}
+		resolvedKey := fromTemplate(key, context)
+		var result any
+		globalContext.Update(namespacedKey(contextNamespace(namespace, context), resolvedKey), cacheTimeout,
+			func(value any, exists bool) (any, bool) {
+				current := any(0)
+				if exists {
+					if current, err = cacheNumber(value); err != nil {
+						return nil, false
+					}
+				}
+				result = addNumbers(current, delta)
+				return result, true
+			})
+		if err != nil {
+			doPanic(requestId, "cache key '%s' is not a number: %v", resolvedKey, err)
+		}
+		if contextTarget != "" {
+			(&PathAccessor{context}).Set(contextTarget, result)
+		}
+	}
+}
+
+// cacheNumber converts value to an int, if possible, or a float64.
+func cacheNumber(value any) (any, error) {
+	switch number := value.(type) {
+	case int:
+		return number, nil
+	case int64:
+		return int(number), nil
+	case float64:
+		if number == float64(int(number)) {
+			return int(number), nil
+		}
+		return number, nil
+	case string:
+		if i, err := strconv.Atoi(number); err == nil {
+			return i, nil
+		}
+		return strconv.ParseFloat(number, 64)
+	default:
+		return strconv.ParseFloat(jsonValueString(value), 64)
+	}
+}
+
+func addNumbers(a any, b any) any {
+	ia, aIsInt := a.(int)
+	ib, bIsInt := b.(int)
+	if aIsInt && bIsInt {
+		return ia + ib
+	}
+	return toFloat(a) + toFloat(b)
+}
+
+func toFloat(number any) float64 {
+	if i, ok := number.(int); ok {
+		return float64(i)
+	}
+	return number.(float64)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-pop"] = newActionCachePop
+}
+
+func newActionCachePop(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "cache-pop"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		key           = configMap.Get("key", "").(string)
+		from          = configMap.Get("from", "front").(string)
+		defaultValue  = configMap.Get("default", nil)
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "item").(string)
+	)
+
+	if key == "" {
+		actionSetupPanic(endpoint, __action__, "Must specify a key")
+	}
+	if from != "front" && from != "back" {
+		actionSetupPanic(endpoint, __action__, "Invalid from '%s', expected front or back", from)
+	}
+	log.Printf("| {action:cache-pop=%s/%s}", key, from)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		resolvedKey := fromTemplate(key, context)
+		item, notAList := defaultValue, false
+		globalContext.Update(namespacedKey(contextNamespace(namespace, context), resolvedKey), 0,
+			func(current any, exists bool) (any, bool) {
+				list, isList := current.([]any)
+				if !exists || len(list) == 0 {
+					notAList = exists && !isList
+					return nil, false
+				}
+				if from == "front" {
+					item = list[0]
+					return append([]any{}, list[1:]...), true
+				}
+				item = list[len(list)-1]
+				return append([]any{}, list[:len(list)-1]...), true
+			})
+		if notAList {
+			doPanic(requestId, "cache key '%s' is not a list", resolvedKey)
+		}
+		(&PathAccessor{context}).Set(contextTarget, item)
+	}
+}
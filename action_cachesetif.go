@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["cache-set-if"] = newActionCacheSetIf
+}
+
+func newActionCacheSetIf(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "cache-set-if"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		key           = configMap.Get("key", "").(string)
+		value         = configMap.Get("value", nil)
+		path          = configMap.Get("path", "").(string)
+		absent        = configMap.Get("absent", false).(bool)
+		equals        = configMap.Get("equals", nil)
+		required      = configMap.Get("required", false).(bool)
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		cacheTimeout  = time.Duration(configMap.Get("timeout", 5*60).(int)) * time.Second
+	)
+
+	if key == "" {
+		actionSetupPanic(endpoint, __action__, "Must specify a key")
+	}
+	if (value == nil) == (path == "") {
+		actionSetupPanic(endpoint, __action__, "Must specify exactly one of value or path")
+	}
+	if absent == (equals != nil) {
+		actionSetupPanic(endpoint, __action__, "Must specify exactly one condition: absent or equals")
+	}
+	log.Printf("| {action:cache-set-if=%s}", key)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		newValue, err := resolveCacheValue(path, value, context)
+		if err != nil {
+			doPanic(requestId, "failed to resolve context path '%s': %v", path, err)
+		}
+		var expected string
+		if equals != nil {
+			if template, ok := equals.(string); ok {
+				expected = fromTemplate(template, context)
+			} else {
+				expected = jsonValueString(equals)
+			}
+		}
+		resolvedKey := fromTemplate(key, context)
+		set := globalContext.Update(namespacedKey(contextNamespace(namespace, context), resolvedKey), cacheTimeout,
+			func(current any, exists bool) (any, bool) {
+				if absent {
+					return newValue, !exists
+				}
+				// compared by their text, so that e.g. 1 equals "1"
+				return newValue, exists && jsonValueString(current) == expected
+			})
+		if contextTarget != "" {
+			(&PathAccessor{context}).Set(contextTarget, set)
+		}
+		if !set && required {
+			doPanic(requestId, "condition on cache key '%s' not met", resolvedKey)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	actionProviderMap["grpc-response"] = newActionGrpcResponse
+}
+
+// newActionGrpcResponse replies to a gRPC call with a templated JSON or YAML
+// message, or fails it with a status code. Server streaming methods send
+// every reply immediately.
+func newActionGrpcResponse(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__ = "grpc-response"
+		doPanic    = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap  = PathAccessor{config: config}
+		body, _    = configMap.Must("body")
+		code       = configMap.Get("code", "OK")
+		message    = configMap.Get("message", "").(string)
+		headers    = configMap.Get("headers", []interface{}{}).([]interface{})
+		trailers   = configMap.Get("trailers", []interface{}{}).([]interface{})
+		delay      = configMap.Get("delay", 0).(int)
+	)
+
+	if _, isString := body.(string); !isString && body != nil {
+		// structured replies are rendered as JSON
+		if _, err := json.Marshal(body); err != nil {
+			actionSetupPanic(endpoint, __action__, "Invalid body: %v", err)
+		}
+	}
+	switch value := code.(type) {
+	case int:
+		code = strconv.Itoa(value)
+	case string:
+	default:
+		actionSetupPanic(endpoint, __action__, "Invalid code %v", code)
+	}
+	if _, err := parseGrpcCode(code.(string)); err != nil && !strings.Contains(code.(string), "{{") {
+		actionSetupPanic(endpoint, __action__, "%v", err)
+	}
+	validateMetadata := func(entries []interface{}, name string) {
+		for _, entry := range entries {
+			if entryMap, ok := entry.(map[string]interface{}); !ok || len(entryMap) != 1 {
+				actionSetupPanic(endpoint, __action__, "Invalid %s entry %v, expected <name>: <value>", name, entry)
+			}
+		}
+	}
+	validateMetadata(headers, "header")
+	validateMetadata(trailers, "trailer")
+	log.Printf("| {action:grpc-response=[%v]%v}", code, body)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		writer, ok := response.(*grpcWriter)
+		if !ok {
+			doPanic(requestId, "No gRPC call, use the action in the grpc endpoints of a server")
+		}
+		if delay > 0 && !sleep(time.Duration(delay)*time.Millisecond, request.Context().Done()) {
+			return
+		}
+		for _, header := range headers {
+			for key, value := range header.(map[string]interface{}) {
+				writer.Header().Add(fromTemplate(key, context), fromTemplate(jsonValueString(value), context))
+			}
+		}
+		for _, trailer := range trailers {
+			for key, value := range trailer.(map[string]interface{}) {
+				writer.trailer.Append(fromTemplate(key, context), fromTemplate(jsonValueString(value), context))
+			}
+		}
+
+		statusCode, err := parseGrpcCode(fromTemplate(code.(string), context))
+		if err != nil {
+			doPanic(requestId, "%v", err)
+		}
+		if statusCode != codes.OK {
+			writer.err = status.Error(statusCode, fromTemplate(message, context))
+			return
+		}
+		if body == nil {
+			return
+		}
+		var payload []byte
+		if bodyTpl, isString := body.(string); isString {
+			payload = []byte(fromTemplate(bodyTpl, context))
+		} else if payload, err = json.Marshal(renderTemplates(body, context)); err != nil {
+			doPanic(requestId, "Invalid body: %v", err)
+		}
+		reply, err := writer.decode(payload)
+		if err != nil {
+			doPanic(requestId, "Invalid %s: %v", writer.method.Output().FullName(), err)
+		}
+		if !writer.method.IsStreamingServer() {
+			writer.reply = reply
+		} else if err := writer.send(reply); err != nil {
+			doPanic(requestId, "Failed to send reply: %v", err)
+		}
+	}
+}
@@ -17,10 +17,10 @@ func newActionParseMultiPartForm(endpoint EndpointStruct, config map[string]inte
 		configMap  = PathAccessor{config: config}
 		maxMemory = configMap.Get("maxMemory", 50).(int) * 1024 * 1024
 		contextKey = configMap.Get("contextKey", "multi-part").(string)
-		allowedMethods = map[string]any{"POST": 1, "PUT": 1}
+		allowedMethods = map[string]any{"POST": 1, "PUT": 1, "PATCH": 1, "DELETE": 1}
 	)
 
-	if _, contains := allowedMethods[endpoint.Method]; !contains {
+	if !endpoint.Method.AnyOf(allowedMethods) {
 		actionSetupPanic(endpoint, __action__,
 			"Invalid endpoint method to use this action")
 	}
@@ -18,10 +18,10 @@ func newActionParseYAML(endpoint EndpointStruct, config map[string]interface{})
 		doPanic = makeActionExecutionPanicFn(endpoint, __action__)
 		configMap  = PathAccessor{config: config}
 		contextPath = configMap.Get("contextTarget", "form").(string)
-		allowedMethods = map[string]any{"POST": 1, "PUT": 1}
+		allowedMethods = map[string]any{"POST": 1, "PUT": 1, "PATCH": 1, "DELETE": 1}
 	)
 
-	if _, contains := allowedMethods[endpoint.Method]; !contains {
+	if !endpoint.Method.AnyOf(allowedMethods) {
 		actionSetupPanic(endpoint, __action__,
 			"Invalid endpoint method to use this action")
 	}
@@ -32,6 +32,7 @@ func newActionRequest(endpoint EndpointStruct, configMap map[string]interface{})
 	log.Printf("| {action:request=%v/%v/%v/%v/%v}", method, url, headers, bodyTemplate, delay)
 	return func(requestId string, response http.ResponseWriter, request *http.Request, params httprouter.Params, context map[string]interface{}) {
 		requestBody := fromTemplate(bodyTemplate, context)
+		done := request.Context().Done()
 		if request, err := http.NewRequest(method, fromTemplate(url, context), strings.NewReader(requestBody)); err != nil {
 			panic(fmt.Errorf("[%s] %v", requestId, err))
 		} else {
@@ -44,42 +45,50 @@ func newActionRequest(endpoint EndpointStruct, configMap map[string]interface{})
 					}
 				}
 			}
-			if delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
+			if delay > 0 && !sleep(time.Duration(delay)*time.Millisecond, done) {
+				return
 			}
-			log.Printf("[%s] %s %s: %v", requestId, request.Method, request.URL, request)
-			if response, err := http.DefaultClient.Do(request); err != nil {
-				panic(fmt.Errorf("[%s] ERROR %s %s: %v", requestId, request.Method, request.URL, err))
+			if result, err := performRequest(requestId, request); err != nil {
+				panic(err)
 			} else {
-				log.Printf("[%s] Result %s %s: %v", requestId, request.Method, request.URL, response)
-				responseBody := ""
-				responseData := make(map[string]interface{})
-				if response.Body != nil {
-					defer response.Request.Body.Close()
-					if bytes, err := io.ReadAll(response.Body); err != nil {
-						panic(fmt.Errorf("[%s] %v", requestId, err))
-					} else {
-						responseBody = string(bytes)
-					}
-					contentType := response.Header.Get("Content-Type")
-					switch contentType {
-					case "text/json":
-						if err := json.Unmarshal([]byte(responseBody), &responseData); err != nil {
-							panic(fmt.Errorf("[%s] %v", requestId, err))
-						}
-					case "text/yaml":
-						if err := yaml.Unmarshal([]byte(responseBody), &responseData); err != nil {
-							panic(fmt.Errorf("[%s] %v", requestId, err))
-						}
-					}
-				}
-				context["__request__"] = map[string]interface{}{
-					"status":  response.StatusCode,
-					"body":    responseBody,
-					"data":    responseData,
-					"headers": response.Header.Clone(),
-				}
+				context["__request__"] = result
 			}
 		}
 	}
 }
+
+// performRequest sends request upstream and returns the result the way it is
+// exposed to templates as `__request__`.
+func performRequest(requestId string, request *http.Request) (map[string]interface{}, error) {
+	log.Printf("[%s] %s %s: %v", requestId, request.Method, request.URL, request)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] ERROR %s %s: %v", requestId, request.Method, request.URL, err)
+	}
+	defer response.Body.Close()
+	log.Printf("[%s] Result %s %s: %v", requestId, request.Method, request.URL, response)
+	responseBody := ""
+	responseData := make(map[string]interface{})
+	if bytes, err := io.ReadAll(response.Body); err != nil {
+		return nil, fmt.Errorf("[%s] %v", requestId, err)
+	} else {
+		responseBody = string(bytes)
+	}
+	contentType := response.Header.Get("Content-Type")
+	switch contentType {
+	case "text/json":
+		if err := json.Unmarshal([]byte(responseBody), &responseData); err != nil {
+			return nil, fmt.Errorf("[%s] %v", requestId, err)
+		}
+	case "text/yaml":
+		if err := yaml.Unmarshal([]byte(responseBody), &responseData); err != nil {
+			return nil, fmt.Errorf("[%s] %v", requestId, err)
+		}
+	}
+	return map[string]interface{}{
+		"status":  response.StatusCode,
+		"body":    responseBody,
+		"data":    responseData,
+		"headers": response.Header.Clone(),
+	}, nil
+}
@@ -26,14 +26,19 @@ func newActionResponse(endpoint EndpointStruct, config map[string]interface{}) A
 		responseBody = configMap.Get("body", "").(string)
 		responseLocalFile = configMap.Get("localFile", "").(string)
 		responseCachedFile = configMap.Get("cachedFile", "").(string)
+		responseChunks = configMap.Get("chunks", []interface{}{}).([]interface{})
+		chunkDelay = configMap.Get("chunkDelay", 0).(int)
 		responseWriter ActionHandler
 		delay        = configMap.Get("delay", 0).(int)
+		fault, faultErr = decodeFaultConfig(configMap.Get("fault", nil))
 	)
 
 	log.Printf("| {action:response=[%v]%v/%s/%v}", status, headers, responseBody, delay)
 
+	// an explicitly empty body is a valid option, e.g. for 204 responses
+	_, hasBody := config["body"]
 	selectedResponses := 0
-	if responseBody != "" {
+	if hasBody {
 		selectedResponses ++
 	}
 	if responseLocalFile != "" {
@@ -42,8 +47,14 @@ func newActionResponse(endpoint EndpointStruct, config map[string]interface{}) A
 	if responseCachedFile != "" {
 		selectedResponses ++
 	}
+	if len(responseChunks) > 0 {
+		selectedResponses ++
+	}
+	if faultErr != nil {
+		actionSetupPanic(endpoint, __action__, "Invalid fault: %v", faultErr)
+	}
 	if selectedResponses != 1 {
-		actionSetupPanic(endpoint, __action__, "Must specify exactly one of the following options:\n\t- body\n\t- localFile\n\t- cachedFile")
+		actionSetupPanic(endpoint, __action__, "Must specify exactly one of the following options:\n\t- body\n\t- localFile\n\t- cachedFile\n\t- chunks")
 	}
 
 	statusWriter := func(requestId string, response http.ResponseWriter, context map[string]any) {
@@ -56,10 +67,12 @@ func newActionResponse(endpoint EndpointStruct, config map[string]interface{}) A
 		}
 	}
 
-	if responseBody != "" {
+	if hasBody {
 		responseWriter = func(requestId string, response http.ResponseWriter, request *http.Request, params httprouter.Params, context map[string]interface{}) {
+			// render before writing the status, so that failures can still be answered
+			body := fromTemplate(responseBody, context)
 			statusWriter(requestId, response, context)
-			response.Write([]byte(fromTemplate(responseBody, context)))
+			response.Write([]byte(body))
 		}
 
 	} else if responseLocalFile != "" {
@@ -69,19 +82,60 @@ func newActionResponse(endpoint EndpointStruct, config map[string]interface{}) A
 				doPanic(requestId, "Error opening local file '%s': %v",
 					resolvedLocalPath, err)
 			} else {
+				defer file.Close()
 				finfo, err := file.Stat()
 				if err != nil {
 					log.Panicf("[%s] action:error: %v", requestId, err)
 				}
-				response.Header().Add("Content-Type", "application/octet-stream")
-				response.Header().Add("Content-Disposition", "attachment; filename="+finfo.Name())
-				response.Header().Add("Content-Transfer-Encoding", "binary")
+				// serve as download unless a content type was configured
+				if response.Header().Get("Content-Type") == "" {
+					response.Header().Add("Content-Type", "application/octet-stream")
+					response.Header().Add("Content-Disposition", "attachment; filename="+finfo.Name())
+					response.Header().Add("Content-Transfer-Encoding", "binary")
+				}
 				response.Header().Add("Content-Length", fmt.Sprintf("%d", finfo.Size()))
 				statusWriter(requestId, response, context)
 				io.Copy(response, file)
 			}
 		}
 
+	} else if len(responseChunks) > 0 {
+		// chunks are sent as soon as they are written, with their own or the
+		// default delay in between
+		type chunk struct {
+			body  string
+			delay int
+		}
+		chunks := make([]chunk, 0, len(responseChunks))
+		for i, entry := range responseChunks {
+			switch entry := entry.(type) {
+			case string:
+				chunks = append(chunks, chunk{entry, chunkDelay})
+			case map[string]interface{}:
+				body, _ := entry["body"].(string)
+				delay, ok := entry["delay"].(int)
+				if !ok {
+					delay = chunkDelay
+				}
+				chunks = append(chunks, chunk{body, delay})
+			default:
+				actionSetupPanic(endpoint, __action__, "Invalid chunk #%d, expected a string or {body, delay}", i)
+			}
+		}
+		responseWriter = func(requestId string, response http.ResponseWriter, request *http.Request, params httprouter.Params, context map[string]interface{}) {
+			controller := http.NewResponseController(response)
+			statusWriter(requestId, response, context)
+			for i, chunk := range chunks {
+				if i > 0 && chunk.delay > 0 && !sleep(time.Duration(chunk.delay)*time.Millisecond, request.Context().Done()) {
+					return
+				}
+				if _, err := response.Write([]byte(fromTemplate(chunk.body, context))); err != nil {
+					return
+				}
+				controller.Flush()
+			}
+		}
+
 	} else {
 		responseWriter = func(requestId string, response http.ResponseWriter, _ *http.Request, _ httprouter.Params, context map[string]interface{}) {
 			resolvedCachePath := fromTemplate(responseCachedFile, context)
@@ -103,12 +157,28 @@ func newActionResponse(endpoint EndpointStruct, config map[string]interface{}) A
 				log.Panicf("invalid header entry in config: %v", header)
 			} else {
 				for key, value := range headerMap {
-					response.Header().Set(fromTemplate(key, context), fromTemplate(value.(string), context))
+					key = fromTemplate(key, context)
+					switch value := value.(type) {
+					case []interface{}:
+						// several values, e.g. of Set-Cookie
+						response.Header().Del(key)
+						for _, item := range value {
+							response.Header().Add(key, fromTemplate(item.(string), context))
+						}
+					default:
+						response.Header().Set(key, fromTemplate(value.(string), context))
+					}
 				}
 			}
 		}
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
+		if delay > 0 && !sleep(time.Duration(delay)*time.Millisecond, request.Context().Done()) {
+			return
+		}
+		if writer, answered := applyFault(fault, requestId, response, request); answered {
+			return
+		} else if writer != nil {
+			defer writer.finish()
+			response = writer
 		}
 		responseWriter(requestId, response, request, params, context)
 	}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChunkedResponse(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /chunks/:name
+    method: GET
+    actions:
+      - type: response
+        params:
+          chunks: ["Hello", {body: " {{.params.name}}", delay: 100}]
+`}))
+	defer server.Close()
+
+	start := time.Now()
+	response, err := http.Get(server.URL + "/chunks/world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.ContentLength != -1 {
+		t.Errorf("got Content-Length %d, want a chunked response", response.ContentLength)
+	}
+	// the first chunk is flushed before the delay of the second one
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(response.Body, first); err != nil || string(first) != "Hello" {
+		t.Fatalf("got first chunk %q, error %v", first, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("first chunk received after %s, want it before the delay", elapsed)
+	}
+	rest, _ := io.ReadAll(response.Body)
+	if string(rest) != " world" {
+		t.Errorf("got second chunk %q", rest)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("second chunk received after %s, want the delay", elapsed)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["responses"] = newActionResponses
+}
+
+const (
+	responsesModeSequence   = "sequence"
+	responsesModeRoundRobin = "round-robin"
+	responsesModeRandom     = "random"
+
+	responsesEndStick = "stick"
+	responsesEndLoop  = "loop"
+)
+
+// Positions of the sequence and round-robin responses. They are kept apart
+// from the scenarios, so they are not listed as such, but reset with them.
+var responseCounters = &responseCounterStore{counters: make(map[string]int)}
+
+// responseCounterStore keeps the index of the next response by counter key,
+// missing counters start at index 0.
+type responseCounterStore struct {
+	lock     sync.Mutex
+	counters map[string]int
+}
+
+// Advance atomically moves the counter of key to the index returned by next
+// and returns its previous index.
+func (store *responseCounterStore) Advance(key string, next func(index int) int) int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	index := store.counters[key]
+	store.counters[key] = next(index)
+	return index
+}
+
+func (store *responseCounterStore) Reset(key string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.counters, key)
+}
+
+// ResetNamespace resets all counters of namespace.
+func (store *responseCounterStore) ResetNamespace(namespace string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key := range store.counters {
+		if _, ok := inNamespace(namespace, key); ok {
+			delete(store.counters, key)
+		}
+	}
+}
+
+func (store *responseCounterStore) ResetAll() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.counters = make(map[string]int)
+}
+
+// newActionResponses selects one of several response actions per request.
+func newActionResponses(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__  = "responses"
+		configMap   = PathAccessor{config: config}
+		mode        = configMap.Get("mode", responsesModeSequence).(string)
+		end         = configMap.Get("end", responsesEndStick).(string)
+		name        = configMap.Get("name", endpoint.Method.String()+" "+endpoint.Url).(string)
+		namespace   = configMap.Get("namespace", "").(string)
+		responses   = configMap.Get("responses", []interface{}{}).([]interface{})
+		handlers    = make([]ActionHandler, 0, len(responses))
+		weights     = make([]int, 0, len(responses))
+		totalWeight = 0
+	)
+
+	if len(responses) == 0 {
+		actionSetupPanic(endpoint, __action__, "Must specify at least one response")
+	}
+	for i, response := range responses {
+		responseConfig, ok := response.(map[string]interface{})
+		if !ok {
+			actionSetupPanic(endpoint, __action__, "Invalid response #%d, expected the params of a response action", i)
+		}
+		weight, ok := responseConfig["weight"].(int)
+		if !ok {
+			weight = 1
+		}
+		if weight < 0 {
+			actionSetupPanic(endpoint, __action__, "Invalid weight %d of response #%d", weight, i)
+		}
+		params := make(map[string]interface{}, len(responseConfig))
+		for key, value := range responseConfig {
+			if key != "weight" {
+				params[key] = value
+			}
+		}
+		handlers = append(handlers, newActionResponse(endpoint, params))
+		weights = append(weights, weight)
+		totalWeight += weight
+	}
+	log.Printf("| {action:responses=%s/%d}", mode, len(handlers))
+
+	// advance moves the counter of name to the index returned by next and
+	// returns the current index, the initial index is 0
+	advance := func(context map[string]interface{}, next func(index int) int) int {
+		key := namespacedKey(contextNamespace(namespace, context), fromTemplate(name, context))
+		index := responseCounters.Advance(key, next)
+		return min(index, len(handlers)-1)
+	}
+
+	var selectResponse func(context map[string]interface{}) int
+	switch mode {
+	case responsesModeSequence:
+		var next func(index int) int
+		switch end {
+		case responsesEndStick:
+			next = func(index int) int { return min(index+1, len(handlers)-1) }
+		case responsesEndLoop:
+			next = func(index int) int { return (index + 1) % len(handlers) }
+		default:
+			actionSetupPanic(endpoint, __action__, "Unsupported end '%s', use stick or loop", end)
+		}
+		selectResponse = func(context map[string]interface{}) int {
+			return advance(context, next)
+		}
+	case responsesModeRoundRobin:
+		selectResponse = func(context map[string]interface{}) int {
+			return advance(context, func(index int) int {
+				return (index + 1) % len(handlers)
+			})
+		}
+	case responsesModeRandom:
+		if totalWeight == 0 {
+			actionSetupPanic(endpoint, __action__, "At least one response must have a positive weight")
+		}
+		selectResponse = func(map[string]interface{}) int {
+			pick := rand.Intn(totalWeight)
+			for i, weight := range weights {
+				if pick < weight {
+					return i
+				}
+				pick -= weight
+			}
+			return len(handlers) - 1
+		}
+	default:
+		actionSetupPanic(endpoint, __action__, "Unsupported mode '%s', use sequence, round-robin or random", mode)
+	}
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		index := selectResponse(context)
+		context["__response_index__"] = index
+		handlers[index](requestId, response, request, params, context)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestResponsesModes(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /sequence
+    method: GET
+    actions:
+      - type: responses
+        params:
+          name: test-responses-sequence
+          responses:
+            - {status: 503, body: first}
+            - {body: second}
+  - url: /loop
+    method: GET
+    actions:
+      - type: responses
+        params:
+          end: loop
+          responses:
+            - {body: first}
+            - {body: second}
+  - url: /round-robin
+    method: GET
+    actions:
+      - type: responses
+        params:
+          mode: round-robin
+          name: test-responses-round-robin
+          responses:
+            - {body: a}
+            - {body: b}
+            - {body: c}
+  - url: /random
+    method: GET
+    actions:
+      - type: responses
+        params:
+          mode: random
+          responses:
+            - {body: never, weight: 0}
+            - {body: '{{.__response_index__}}'}
+`})
+	defer responseCounters.ResetAll()
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"/sequence", []string{"503 first", "200 second", "200 second"}},
+		{"/loop", []string{"200 first", "200 second", "200 first"}},
+		{"/round-robin", []string{"200 a", "200 b", "200 c", "200 a"}},
+		{"/random", []string{"200 1", "200 1", "200 1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			for i, want := range test.want {
+				status, body := serveTest(server, http.MethodGet, test.url, "", nil)
+				if response := fmt.Sprintf("%d %s", status, body); response != want {
+					t.Errorf("request %d: got %q, want %q", i, response, want)
+				}
+			}
+		})
+	}
+
+	// resetting the scenario of the counter restarts the sequence
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/scenarios/test-responses-sequence", "", nil); status >= 300 {
+		t.Fatalf("reset: got %d", status)
+	}
+	if status, body := serveTest(server, http.MethodGet, "/sequence", "", nil); status != http.StatusServiceUnavailable {
+		t.Errorf("got %d %s after a reset, want the first response", status, body)
+	}
+}
+
+func TestResponseCountersConcurrent(t *testing.T) {
+	store := &responseCounterStore{counters: make(map[string]int)}
+	var wait sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			store.Advance("counter", func(index int) int { return index + 1 })
+		}()
+	}
+	wait.Wait()
+
+	if index := store.Advance("counter", func(index int) int { return 0 }); index != 100 {
+		t.Errorf("index is %d, want 100", index)
+	}
+	store.Advance(namespacedKey("a", "counter"), func(index int) int { return 3 })
+	store.ResetNamespace("a")
+	if index := store.Advance(namespacedKey("a", "counter"), func(index int) int { return index }); index != 0 {
+		t.Errorf("index is %d after a reset, want 0", index)
+	}
+}
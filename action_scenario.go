@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["scenario"] = newActionScenario
+}
+
+func newActionScenario(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "scenario"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		name          = configMap.Get("name", "").(string)
+		state         = configMap.Get("state", "").(string)
+		from          = configMap.Get("from", "").(string)
+		required      = configMap.Get("required", false).(bool)
+		namespace     = configMap.Get("namespace", "").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+	)
+
+	if name == "" || state == "" {
+		actionSetupPanic(endpoint, __action__, "Must specify a scenario name and the target state")
+	}
+	log.Printf("| {action:scenario=%s:%s->%s}", name, from, state)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		resolvedName := fromTemplate(name, context)
+		key := namespacedKey(contextNamespace(namespace, context), resolvedName)
+		transitioned := scenarios.Transition(key, fromTemplate(from, context), fromTemplate(state, context))
+		if contextTarget != "" {
+			(&PathAccessor{context}).Set(contextTarget, transitioned)
+		}
+		if !transitioned && required {
+			doPanic(requestId, "scenario '%s' is not in state '%s'", resolvedName, from)
+		}
+	}
+}
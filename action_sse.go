@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["sse"] = newActionSSE
+}
+
+type sseEvent struct {
+	Event string
+	Id    string
+	Data  string
+	// milliseconds to wait before sending the event
+	Delay int
+	// configured data, non-string values are sent as JSON
+	data interface{}
+}
+
+// write sends event in the text/event-stream format, data may span several
+// lines.
+func (event *sseEvent) write(response http.ResponseWriter) error {
+	message := &strings.Builder{}
+	if event.Event != "" {
+		fmt.Fprintf(message, "event: %s\n", event.Event)
+	}
+	if event.Id != "" {
+		fmt.Fprintf(message, "id: %s\n", event.Id)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(message, "data: %s\n", line)
+	}
+	message.WriteString("\n")
+	_, err := response.Write([]byte(message.String()))
+	return err
+}
+
+// newActionSSE streams Server-Sent Events: the configured events first, then
+// optionally changes of the global cache until the client disconnects.
+func newActionSSE(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__  = "sse"
+		configMap   = PathAccessor{config: config}
+		retry       = configMap.Get("retry", 0).(int)
+		keepAlive   = time.Duration(configMap.Get("keepAlive", 15).(int)) * time.Second
+		duration    = time.Duration(configMap.Get("duration", 0).(int)) * time.Second
+		eventList   = configMap.Get("events", []interface{}{}).([]interface{})
+		cacheFeed   = configMap.Get("cache", nil)
+		feedPrefix  string
+		feedEvent   = "cache"
+		feedNS      = configMap.Get("namespace", "").(string)
+		defaultWait = configMap.Get("delay", 0).(int)
+		events      = make([]sseEvent, 0, len(eventList))
+	)
+
+	for i, entry := range eventList {
+		eventMap, ok := entry.(map[string]interface{})
+		if !ok {
+			actionSetupPanic(endpoint, __action__, "Invalid event #%d, expected {event, id, data, delay}", i)
+		}
+		event := sseEvent{Delay: defaultWait}
+		event.Event, _ = eventMap["event"].(string)
+		event.Id = fmt.Sprint(eventMap["id"])
+		if eventMap["id"] == nil {
+			event.Id = ""
+		}
+		event.data = eventMap["data"]
+		if _, err := json.Marshal(event.data); err != nil {
+			actionSetupPanic(endpoint, __action__, "Invalid data of event #%d: %v", i, err)
+		}
+		if delay, ok := eventMap["delay"].(int); ok {
+			event.Delay = delay
+		}
+		events = append(events, event)
+	}
+	if cacheFeed != nil {
+		feedConfig, ok := cacheFeed.(map[string]interface{})
+		if !ok {
+			actionSetupPanic(endpoint, __action__, "Invalid cache feed, expected {prefix, event}")
+		}
+		feedPrefix, _ = feedConfig["prefix"].(string)
+		if event, ok := feedConfig["event"].(string); ok {
+			feedEvent = event
+		}
+	}
+	if len(events) == 0 && cacheFeed == nil {
+		actionSetupPanic(endpoint, __action__, "Must specify events and/or a cache feed")
+	}
+	log.Printf("| {action:sse=%d events, cache feed: %v}", len(events), cacheFeed != nil)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		var (
+			controller = http.NewResponseController(response)
+			changes    chan CacheChange
+			namespace  = contextNamespace(feedNS, context)
+		)
+		if err := validateNamespace(namespace); err != nil {
+			panic(err)
+		}
+		// closed once the client goes away or the server shuts down
+		stop, release := stopOf(request)
+		defer release()
+		// subscribe before sending the events, so that no change is missed
+		if cacheFeed != nil {
+			changes = make(chan CacheChange, 64)
+			unsubscribe := globalContext.Subscribe(func(change CacheChange) {
+				key, ok := inNamespace(namespace, change.Key)
+				if !ok || !strings.HasPrefix(key, feedPrefix) {
+					return
+				}
+				change.Key = key
+				select {
+				case changes <- change:
+				default:
+					log.Printf("[%s] sse: client too slow, dropping change of '%s'", requestId, key)
+				}
+			})
+			defer unsubscribe()
+		}
+
+		header := response.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		response.WriteHeader(http.StatusOK)
+		if retry > 0 {
+			fmt.Fprintf(response, "retry: %d\n\n", retry)
+		}
+		controller.Flush()
+
+		// keep-alives and cache changes are also sent while waiting for the
+		// delayed events
+		var keepAlives <-chan time.Time
+		if keepAlive > 0 {
+			ticker := time.NewTicker(keepAlive)
+			defer ticker.Stop()
+			keepAlives = ticker.C
+		}
+		// wait streams keep-alives and cache changes until done fires, it
+		// reports whether the stream is still open
+		wait := func(done <-chan time.Time) bool {
+			for {
+				select {
+				case <-stop:
+					return false
+				case <-done:
+					return true
+				case <-keepAlives:
+					if _, err := response.Write([]byte(": keep-alive\n\n")); err != nil {
+						return false
+					}
+				case change := <-changes:
+					data, _ := json.Marshal(map[string]any{"key": change.Key, "value": change.Value, "deleted": change.Deleted})
+					event := sseEvent{Event: feedEvent, Data: string(data)}
+					if event.write(response) != nil {
+						return false
+					}
+				}
+				controller.Flush()
+			}
+		}
+
+		for _, event := range events {
+			if event.Delay > 0 {
+				delay := time.NewTimer(time.Duration(event.Delay) * time.Millisecond)
+				open := wait(delay.C)
+				delay.Stop()
+				if !open {
+					return
+				}
+			}
+			event.Event = fromTemplate(event.Event, context)
+			event.Id = fromTemplate(event.Id, context)
+			if data, isString := event.data.(string); isString {
+				event.Data = fromTemplate(data, context)
+			} else if data, err := json.Marshal(renderTemplates(event.data, context)); err != nil {
+				panic(err)
+			} else {
+				event.Data = string(data)
+			}
+			if event.write(response) != nil {
+				return
+			}
+			controller.Flush()
+		}
+		if changes == nil && duration <= 0 {
+			return
+		}
+
+		// keep the stream open for the cache feed and/or the configured duration
+		var timeout <-chan time.Time
+		if duration > 0 {
+			timeout = time.After(duration)
+		}
+		wait(timeout)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testSSEConfig = `
+endpoints:
+  - url: /events
+    method: GET
+    actions:
+      - type: sse
+        params:
+          retry: 1000
+          events:
+            - {event: greeting, id: 1, data: hello}
+            - {data: {count: 2}, delay: 50}
+            - data: "two\nlines"
+  - url: /feed
+    method: GET
+    namespace: '{{index .__headers__ "X-Test-Id"}}'
+    actions:
+      - type: sse
+        params:
+          cache: {prefix: order.}
+`
+
+func TestSSEEvents(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": testSSEConfig}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("got Content-Type %q", contentType)
+	}
+	// the stream ends after the events without a cache feed or duration
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "retry: 1000\n\n" +
+		"event: greeting\nid: 1\ndata: hello\n\n" +
+		"data: {\"count\":2}\n\n" +
+		"data: two\ndata: lines\n\n"
+	if string(body) != want {
+		t.Errorf("got stream %q, want %q", body, want)
+	}
+}
+
+func TestSSEStructuredData(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /orders/:id/events
+    method: GET
+    actions:
+      - type: sse
+        params:
+          events:
+            - event: order
+              data:
+                id: '{{index .params "id"}}'
+                note: '{{index .__headers__ "X-Note"}}'
+                items: [1, 2]
+`}))
+	defer server.Close()
+
+	// substituted values with quotes are escaped in the JSON
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/orders/7/events", nil)
+	request.Header.Set("X-Note", `say "hi"`)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "event: order\n" +
+		`data: {"id":"7","items":[1,2],"note":"say \"hi\""}` + "\n\n"
+	if string(body) != want {
+		t.Errorf("got stream %q, want %q", body, want)
+	}
+}
+
+func TestSSECacheFeedByNamespace(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": testSSEConfig}))
+	defer server.Close()
+	defer clearNamespace("test-feed-a")
+	defer clearNamespace("test-feed-b")
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/feed", nil)
+	request.Header.Set("X-Test-Id", "test-feed-a")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	// the headers are sent after subscribing, so no change is missed
+	globalContext.Add(namespacedKey("test-feed-b", "order.1"), "other namespace", 0)
+	globalContext.Add(namespacedKey("test-feed-a", "customer.1"), "other prefix", 0)
+	globalContext.Add(namespacedKey("test-feed-a", "order.1"), "placed", 0)
+	globalContext.Delete(namespacedKey("test-feed-a", "order.1"))
+
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for _, want := range []string{
+		"event: cache",
+		`data: {"deleted":false,"key":"order.1","value":"placed"}`,
+		"",
+		"event: cache",
+		`data: {"deleted":true,"key":"order.1","value":"placed"}`,
+	} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("got line %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no line %q received", want)
+		}
+	}
+}
+
+func TestSSEFeedDuringEventDelays(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /delayed
+    method: GET
+    namespace: test-feed-delayed
+    actions:
+      - type: sse
+        params:
+          keepAlive: 1
+          cache: {prefix: order.}
+          events:
+            - data: first
+            - {data: second, delay: 1500}
+`}))
+	defer server.Close()
+	defer clearNamespace("test-feed-delayed")
+
+	response, err := http.Get(server.URL + "/delayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// changes and keep-alives are not held back by the delay of the second event
+	for i, want := range []string{
+		"data: first",
+		"",
+		"event: cache",
+		`data: {"deleted":false,"key":"order.1","value":"placed"}`,
+		"",
+		": keep-alive",
+		"",
+		"data: second",
+	} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("got line %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no line %q received", want)
+		}
+		if i == 1 {
+			globalContext.Add(namespacedKey("test-feed-delayed", "order.1"), "placed", 0)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["validate"] = newActionValidate
+}
+
+// newActionValidate validates the path params, query, headers and parsed body
+// of a request against JSON Schemas, given inline or by an operation of an
+// OpenAPI document. Violations are either stored in the context or fail the
+// request with `status`, listing them in the error response.
+func newActionValidate(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "validate"
+		configMap     = PathAccessor{config: config}
+		spec          = configMap.Get("openapi", "").(string)
+		bodyPath      = configMap.Get("bodyPath", "form").(string)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		status        = configMap.Get("status", http.StatusBadRequest).(int)
+		validator     = schemaValidator{doc: &openapiDocument{root: config}}
+		schemas       = map[string]interface{}{}
+		bodyRequired  = true
+		// names of the validated params by the names of the router params
+		paramNames = map[string]string{}
+	)
+
+	if spec == "" {
+		for _, part := range []string{"params", "query", "headers", "body"} {
+			if schema, exists := config[part]; exists {
+				schemas[part] = schema
+			}
+		}
+		if len(schemas) == 0 {
+			actionSetupPanic(endpoint, __action__, "Must specify an openapi document or any of params, query, headers and body")
+		}
+	} else {
+		doc, err := loadOpenapiDocument(spec)
+		if err != nil {
+			actionSetupPanic(endpoint, __action__, "%v", err)
+		}
+		openapiUrl, pathItem, operation, err := doc.findOperation(
+			configMap.Get("operation", "").(string),
+			configMap.Get("method", "").(string),
+			configMap.Get("path", "").(string),
+			endpoint,
+		)
+		if err != nil {
+			actionSetupPanic(endpoint, __action__, "%v", err)
+		}
+		validator.doc = doc
+		schemas, bodyRequired = doc.requestSchemas(pathItem, operation)
+		paramNames = openapiParamNames(openapiUrl, endpoint.Url)
+	}
+	if err := validator.compilePatterns(schemas); err != nil {
+		actionSetupPanic(endpoint, __action__, "%v", err)
+	}
+	log.Printf("| {action:validate=%s %d schemas}", spec, len(schemas))
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		errs := []string{}
+		if schema, exists := schemas["params"]; exists {
+			properties, _ := validator.doc.resolve(schema)["properties"].(map[string]interface{})
+			values := make(map[string]interface{}, len(params))
+			for _, param := range params {
+				name, renamed := paramNames[param.Key]
+				if !renamed {
+					name = param.Key
+				}
+				values[name] = validator.coerce(properties[name], param.Value)
+			}
+			errs = append(errs, validator.validate(schema, values, "params")...)
+		}
+		if schema, exists := schemas["query"]; exists {
+			properties, _ := validator.doc.resolve(schema)["properties"].(map[string]interface{})
+			values := map[string]interface{}{}
+			for key, texts := range request.URL.Query() {
+				values[key] = validator.coerceAll(properties[key], texts)
+			}
+			errs = append(errs, validator.validate(schema, values, "query")...)
+		}
+		if schema, exists := schemas["headers"]; exists {
+			// only the declared headers are validated, clients send many more
+			properties, _ := validator.doc.resolve(schema)["properties"].(map[string]interface{})
+			values := map[string]interface{}{}
+			for name, property := range properties {
+				if texts := request.Header.Values(name); len(texts) > 0 {
+					values[name] = validator.coerceAll(property, texts)
+				}
+			}
+			errs = append(errs, validator.validate(schema, values, "headers")...)
+		}
+		if schema, exists := schemas["body"]; exists {
+			body, err := (&PathAccessor{context}).Must(bodyPath)
+			if err != nil && bodyRequired {
+				errs = append(errs, "body: required")
+			} else if err == nil {
+				errs = append(errs, validator.validate(schema, body, "body")...)
+			}
+		}
+
+		if contextTarget != "" {
+			violations := make([]interface{}, len(errs))
+			for i, err := range errs {
+				violations[i] = err
+			}
+			(&PathAccessor{context}).Set(contextTarget, violations)
+			return
+		}
+		if len(errs) > 0 {
+			log.Printf("[%s] request validation failed:\n  %s", requestId, strings.Join(errs, "\n  "))
+			panic(&ActionError{
+				RequestId: requestId,
+				Action:    __action__,
+				Message:   "request validation failed",
+				Errors:    errs,
+				Status:    status,
+			})
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["ws-close"] = newActionWsClose
+}
+
+// newActionWsClose closes the websocket connection of the event with a close
+// code and reason. Later actions of the event are skipped.
+func newActionWsClose(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__ = "ws-close"
+		doPanic    = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap  = PathAccessor{config: config}
+		code       = configMap.Get("code", websocket.CloseNormalClosure)
+		reason     = configMap.Get("reason", "").(string)
+	)
+
+	if endpoint.Websocket == nil {
+		actionSetupPanic(endpoint, __action__, "Only available in websocket endpoints")
+	}
+	switch code.(type) {
+	case int, string:
+	default:
+		actionSetupPanic(endpoint, __action__, "Invalid close code %v", code)
+	}
+	log.Printf("| {action:ws-close=%v %s}", code, reason)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		writer, ok := response.(*websocketWriter)
+		if !ok {
+			doPanic(requestId, "No websocket connection, use the action in the websocket events of the endpoint")
+		}
+		closeCode, isInt := code.(int)
+		if !isInt {
+			var err error
+			if closeCode, err = strconv.Atoi(fromTemplate(code.(string), context)); err != nil {
+				doPanic(requestId, "Invalid close code: %v", err)
+			}
+		}
+		writer.session.close(closeCode, fromTemplate(reason, context))
+	}
+}
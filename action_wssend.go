@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	actionProviderMap["ws-send"] = newActionWsSend
+}
+
+// newActionWsSend sends a templated message over the websocket connection of
+// the event, or to all connections of the endpoint.
+func newActionWsSend(endpoint EndpointStruct, config map[string]interface{}) ActionHandler {
+	var (
+		__action__    = "ws-send"
+		doPanic       = makeActionExecutionPanicFn(endpoint, __action__)
+		configMap     = PathAccessor{config: config}
+		message, _    = configMap.Must("message")
+		binary        = configMap.Get("binary", false).(bool)
+		broadcast     = configMap.Get("broadcast", false).(bool)
+		delay         = configMap.Get("delay", 0).(int)
+		contextTarget = configMap.Get("contextTarget", "").(string)
+		messageType   = websocket.TextMessage
+	)
+
+	if endpoint.Websocket == nil {
+		actionSetupPanic(endpoint, __action__, "Only available in websocket endpoints")
+	}
+	switch message.(type) {
+	case nil:
+		actionSetupPanic(endpoint, __action__, "Must specify a message")
+	case string:
+	default:
+		// structured messages are sent as JSON
+		if _, err := json.Marshal(message); err != nil {
+			actionSetupPanic(endpoint, __action__, "Invalid message: %v", err)
+		}
+	}
+	if binary {
+		messageType = websocket.BinaryMessage
+	}
+	log.Printf("| {action:ws-send=%v broadcast:%v}", message, broadcast)
+
+	return func(
+		requestId string,
+		response http.ResponseWriter,
+		request *http.Request,
+		params httprouter.Params,
+		context map[string]interface{},
+	) {
+		writer, ok := response.(*websocketWriter)
+		if !ok {
+			doPanic(requestId, "No websocket connection, use the action in the websocket events of the endpoint")
+		}
+		if delay > 0 && !sleep(time.Duration(delay)*time.Millisecond, request.Context().Done()) {
+			return
+		}
+		var data []byte
+		if messageTpl, isString := message.(string); isString {
+			data = []byte(fromTemplate(messageTpl, context))
+		} else {
+			var err error
+			if data, err = json.Marshal(renderTemplates(message, context)); err != nil {
+				doPanic(requestId, "Invalid message: %v", err)
+			}
+		}
+		if broadcast {
+			sent := writer.session.endpoint.broadcast(messageType, data)
+			if contextTarget != "" {
+				(&PathAccessor{context}).Set(contextTarget, sent)
+			}
+			return
+		}
+		if err := writer.session.send(messageType, data); err != nil {
+			doPanic(requestId, "Failed to send message: %v", err)
+		}
+	}
+}
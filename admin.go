@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// All requests below this prefix are served by the admin API instead of the
+// configured endpoints.
+const adminPrefix = "/__admin/"
+
+// AdminRouteProvider registers admin routes for the given server.
+type AdminRouteProvider func(router *httprouter.Router, server *dummyServer)
+
+var adminRouteProviders = map[string]AdminRouteProvider{}
+
+func newAdminRouter(server *dummyServer) *httprouter.Router {
+	router := httprouter.New()
+	for _, provider := range adminRouteProviders {
+		provider(router, server)
+	}
+	return router
+}
+
+// readAdminBody decodes a JSON or YAML request body into target. As JSON is a
+// subset of YAML, both are handled by the YAML decoder, which also keeps
+// integers as int the way the config file loader does.
+func readAdminBody(request *http.Request, target any) error {
+	defer request.Body.Close()
+	bytes, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(bytes, target)
+}
+
+// writeAdminResponse encodes value as YAML if the client accepts it and as
+// JSON otherwise.
+func writeAdminResponse(response http.ResponseWriter, request *http.Request, status int, value any) {
+	var (
+		bytes []byte
+		err   error
+	)
+	if strings.Contains(request.Header.Get("Accept"), "yaml") {
+		response.Header().Set("Content-Type", "application/yaml")
+		bytes, err = yaml.Marshal(value)
+	} else {
+		response.Header().Set("Content-Type", "application/json")
+		bytes, err = json.MarshalIndent(value, "", "  ")
+	}
+	if err != nil {
+		log.Printf("[admin] failed to encode response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(status)
+	response.Write(bytes)
+}
+
+func writeAdminError(response http.ResponseWriter, request *http.Request, status int, err error) {
+	log.Printf("[admin] %s %s: %v", request.Method, request.URL.Path, err)
+	writeAdminResponse(response, request, status, map[string]string{"error": err.Error()})
+}
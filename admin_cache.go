@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	adminRouteProviders["cache"] = newAdminCacheRoutes
+}
+
+// adminCacheEntry describes a cache key, values are fetched individually.
+type adminCacheEntry struct {
+	Key     string     `json:"key" yaml:"key"`
+	Expires *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+	// remaining time to live in seconds
+	Ttl float64 `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// adminCaches returns the caches by their name in the admin API. They are
+// looked up on every request, as they are replaced on startup.
+func adminCaches() map[string]Cache {
+	return map[string]Cache{"global": globalContext, "files": fileCache}
+}
+
+// adminCacheEntries lists the entries of cache, restricted to the namespace
+// given by the `namespace` query param, if any.
+func adminCacheEntries(cache Cache, request *http.Request) []adminCacheEntry {
+	entries := []adminCacheEntry{}
+	namespace, filtered := adminCacheNamespace(request)
+	for key, entry := range cache.Entries() {
+		if filtered {
+			if _, ok := inNamespace(namespace, key); !ok {
+				continue
+			}
+		}
+		adminEntry := adminCacheEntry{Key: key}
+		if expires := entry.Expires; !expires.IsZero() {
+			adminEntry.Expires = &expires
+			adminEntry.Ttl = time.Until(expires).Seconds()
+		}
+		entries = append(entries, adminEntry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func adminCacheNamespace(request *http.Request) (string, bool) {
+	query := request.URL.Query()
+	return query.Get("namespace"), query.Has("namespace")
+}
+
+// adminCacheTimeout reads the optional `timeout` query param in seconds, 0
+// keeps the entry until it is deleted.
+func adminCacheTimeout(request *http.Request) (time.Duration, error) {
+	timeout := request.URL.Query().Get("timeout")
+	if timeout == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(timeout)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid timeout '%s'", timeout)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func newAdminCacheRoutes(router *httprouter.Router, server *dummyServer) {
+	lookup := func(response http.ResponseWriter, request *http.Request, params httprouter.Params) Cache {
+		cache, exists := adminCaches()[params.ByName("cache")]
+		if !exists {
+			writeAdminError(response, request, http.StatusNotFound,
+				fmt.Errorf("unknown cache '%s', use global or files", params.ByName("cache")))
+		}
+		return cache
+	}
+
+	router.GET(adminPrefix+"cache", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		result := make(map[string][]adminCacheEntry)
+		for name, cache := range adminCaches() {
+			result[name] = adminCacheEntries(cache, request)
+		}
+		writeAdminResponse(response, request, http.StatusOK, result)
+	})
+
+	router.DELETE(adminPrefix+"cache", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		for _, cache := range adminCaches() {
+			cache.Clear()
+		}
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	router.GET(adminPrefix+"cache/:cache", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if cache := lookup(response, request, params); cache != nil {
+			writeAdminResponse(response, request, http.StatusOK, adminCacheEntries(cache, request))
+		}
+	})
+
+	router.DELETE(adminPrefix+"cache/:cache", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		cache := lookup(response, request, params)
+		if cache == nil {
+			return
+		}
+		if namespace, filtered := adminCacheNamespace(request); filtered {
+			for key := range cache.Entries() {
+				if _, ok := inNamespace(namespace, key); ok {
+					cache.Delete(key)
+				}
+			}
+		} else {
+			cache.Clear()
+		}
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	router.GET(adminPrefix+"cache/:cache/*key", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		cache := lookup(response, request, params)
+		if cache == nil {
+			return
+		}
+		key := adminCacheKey(params)
+		value := cache.Get(key, nil)
+		if value == nil {
+			writeAdminError(response, request, http.StatusNotFound, fmt.Errorf("cache key '%s' not found", key))
+		} else if cacheFile, ok := value.(CacheFile); ok {
+			cacheFile.AddHeaders(response)
+			if err := cacheFile.Copy(response); err != nil {
+				writeAdminError(response, request, http.StatusInternalServerError, err)
+			}
+		} else {
+			writeAdminResponse(response, request, http.StatusOK, value)
+		}
+	})
+
+	// Values of the global cache are read from the JSON or YAML body, files
+	// are stored as uploaded together with their Content-Type.
+	router.PUT(adminPrefix+"cache/:cache/*key", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		cache := lookup(response, request, params)
+		if cache == nil {
+			return
+		}
+		key := adminCacheKey(params)
+		if key == "" {
+			writeAdminError(response, request, http.StatusBadRequest, fmt.Errorf("missing cache key"))
+			return
+		}
+		timeout, err := adminCacheTimeout(request)
+		if err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+			return
+		}
+		var value any
+		if params.ByName("cache") == "files" {
+			headers := make(map[string][]string)
+			for _, header := range []string{"Content-Type", "Content-Disposition"} {
+				if values := request.Header.Values(header); len(values) > 0 {
+					headers[header] = values
+				}
+			}
+			defer request.Body.Close()
+			if value, err = NewCacheFileFromReader(request.Body, headers); err != nil {
+				writeAdminError(response, request, http.StatusInternalServerError, err)
+				return
+			}
+		} else if err := readAdminBody(request, &value); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+			return
+		}
+		cache.Add(key, value, timeout)
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	router.DELETE(adminPrefix+"cache/:cache/*key", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if cache := lookup(response, request, params); cache == nil {
+			return
+		} else if key := adminCacheKey(params); !cache.Delete(key) {
+			writeAdminError(response, request, http.StatusNotFound, fmt.Errorf("cache key '%s' not found", key))
+		} else {
+			response.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// adminCacheKey returns the key of a cache route, which may contain slashes.
+func adminCacheKey(params httprouter.Params) string {
+	return strings.TrimPrefix(params.ByName("key"), "/")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAdminCacheValues(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": "endpoints: []"})
+	defer clearNamespace("test-admin-cache")
+	defer clearNamespace("test-admin-cache-other")
+	key := namespacedKey("test-admin-cache", "token/user")
+
+	if status, body := serveTest(server, http.MethodPut, "/__admin/cache/global/"+key+"?timeout=60", `{"token": "abc"}`, nil); status != http.StatusNoContent {
+		t.Fatalf("put: got %d %s", status, body)
+	}
+	// YAML bodies are read as well
+	if status, body := serveTest(server, http.MethodPut, "/__admin/cache/global/"+namespacedKey("test-admin-cache", "plain"), "value: 1", nil); status != http.StatusNoContent {
+		t.Fatalf("put: got %d %s", status, body)
+	}
+	globalContext.Add(namespacedKey("test-admin-cache-other", "plain"), 2, 0)
+
+	status, body := serveTest(server, http.MethodGet, "/__admin/cache/global/"+key, "", nil)
+	value := map[string]string{}
+	if err := json.Unmarshal([]byte(body), &value); status != http.StatusOK || err != nil || value["token"] != "abc" {
+		t.Errorf("get: got %d %s", status, body)
+	}
+
+	// the entries of a namespace with their time to live
+	_, body = serveTest(server, http.MethodGet, "/__admin/cache/global?namespace=test-admin-cache", "", nil)
+	entries := []adminCacheEntry{}
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != namespacedKey("test-admin-cache", "plain") || entries[0].Expires != nil ||
+		entries[1].Key != key || entries[1].Ttl <= 58 || entries[1].Ttl > 60 {
+		t.Errorf("got entries %s", body)
+	}
+	_, body = serveTest(server, http.MethodGet, "/__admin/cache", "", nil)
+	all := map[string][]adminCacheEntry{}
+	if err := json.Unmarshal([]byte(body), &all); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := all["files"]; !exists || len(all["global"]) < 3 {
+		t.Errorf("got caches %s", body)
+	}
+
+	// deleting a namespace keeps the other namespaces
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/cache/global?namespace=test-admin-cache", "", nil); status != http.StatusNoContent {
+		t.Errorf("delete namespace: got %d", status)
+	}
+	if globalContext.Get(key, nil) != nil || globalContext.Get(namespacedKey("test-admin-cache-other", "plain"), nil) != 2 {
+		t.Errorf("got cache %v after deleting the namespace", globalContext.ToMap())
+	}
+
+	otherKey := namespacedKey("test-admin-cache-other", "plain")
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/cache/global/"+otherKey, "", nil); status != http.StatusNoContent {
+		t.Errorf("delete: got %d", status)
+	}
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/cache/global/"+otherKey, "", nil); status != http.StatusNotFound {
+		t.Errorf("delete again: got %d, want 404", status)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/__admin/cache/global/"+otherKey, "", nil); status != http.StatusNotFound {
+		t.Errorf("get deleted: got %d, want 404", status)
+	}
+}
+
+func TestAdminCacheFiles(t *testing.T) {
+	previousPath := __cache_path__
+	__cache_path__ = t.TempDir()
+	defer func() { __cache_path__ = previousPath }()
+	server := newTestServer(t, map[string]string{"config.yaml": "endpoints: []"})
+	key := namespacedKey("test-admin-cache", "avatar")
+	defer fileCache.Delete(key)
+
+	if status, body := serveTest(server, http.MethodPut, "/__admin/cache/files/"+key, "hello", map[string]string{
+		"Content-Type": "text/plain",
+	}); status != http.StatusNoContent {
+		t.Fatalf("put: got %d %s", status, body)
+	}
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/__admin/cache/files/"+key, nil))
+	if response.Code != http.StatusOK || response.Body.String() != "hello" || response.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("get: got %d %q %v", response.Code, response.Body.String(), response.Header())
+	}
+
+	// deleting the key, or wiping all caches, removes the files
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/cache/files/"+key, "", nil); status != http.StatusNoContent {
+		t.Errorf("delete: got %d", status)
+	}
+	if files, _ := os.ReadDir(__cache_path__); len(files) != 0 {
+		t.Errorf("%d files left after the delete", len(files))
+	}
+	serveTest(server, http.MethodPut, "/__admin/cache/files/"+key, "hello", nil)
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/cache", "", nil); status != http.StatusNoContent {
+		t.Errorf("wipe: got %d", status)
+	}
+	if files, _ := os.ReadDir(__cache_path__); len(files) != 0 || fileCache.Get(key, nil) != nil {
+		t.Errorf("%d files left after the wipe", len(files))
+	}
+}
+
+func TestAdminCacheErrors(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": "endpoints: []"})
+	for _, test := range []struct {
+		method string
+		url    string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "/__admin/cache/other", "", http.StatusNotFound},
+		{http.MethodDelete, "/__admin/cache/other/key", "", http.StatusNotFound},
+		{http.MethodPut, "/__admin/cache/global/", "1", http.StatusBadRequest},
+		{http.MethodPut, "/__admin/cache/global/key?timeout=-1", "1", http.StatusBadRequest},
+		{http.MethodPut, "/__admin/cache/global/key?timeout=soon", "1", http.StatusBadRequest},
+		{http.MethodPut, "/__admin/cache/global/key", "{", http.StatusBadRequest},
+	} {
+		if status, body := serveTest(server, test.method, test.url, test.body, nil); status != test.want {
+			t.Errorf("%s %s: got %d %s, want %d", test.method, test.url, status, body, test.want)
+		}
+	}
+	if value := globalContext.Get("key", nil); value != nil {
+		t.Errorf("invalid request stored %v", value)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	adminRouteProviders["endpoints"] = newAdminEndpointRoutes
+}
+
+type adminEndpointEntry struct {
+	Id       string         `json:"id,omitempty" yaml:"id,omitempty"`
+	Source   string         `json:"source" yaml:"source"`
+	Endpoint EndpointStruct `json:"endpoint" yaml:"endpoint"`
+	// virtual hosts of config endpoints
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+}
+
+func newAdminEndpointRoutes(router *httprouter.Router, server *dummyServer) {
+	router.GET(adminPrefix+"endpoints", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		entries := []adminEndpointEntry{}
+		cfg := server.Config()
+		for _, endpoint := range cfg.Endpoints {
+			entries = append(entries, adminEndpointEntry{Source: "config", Endpoint: endpoint})
+		}
+		for _, virtualHost := range cfg.VirtualHosts {
+			for _, endpoint := range virtualHost.Endpoints {
+				entries = append(entries, adminEndpointEntry{Source: "config", Endpoint: endpoint, Hosts: virtualHost.Hosts})
+			}
+		}
+		for _, entry := range server.RuntimeEndpoints() {
+			entries = append(entries, adminEndpointEntry{Id: entry.Id, Source: "runtime", Endpoint: entry.Endpoint})
+		}
+		writeAdminResponse(response, request, http.StatusOK, entries)
+	})
+
+	router.POST(adminPrefix+"endpoints", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		endpoint := EndpointStruct{}
+		if err := readAdminBody(request, &endpoint); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else if id, err := server.AddEndpoint(endpoint); err != nil {
+			writeAdminError(response, request, http.StatusConflict, err)
+		} else {
+			writeAdminResponse(response, request, http.StatusCreated, adminEndpointEntry{Id: id, Source: "runtime", Endpoint: endpoint})
+		}
+	})
+
+	router.DELETE(adminPrefix+"endpoints", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		if err := server.ClearEndpoints(); err != nil {
+			writeAdminError(response, request, http.StatusInternalServerError, err)
+		} else {
+			response.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	router.GET(adminPrefix+"endpoints/:id", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		for _, entry := range server.RuntimeEndpoints() {
+			if entry.Id == id {
+				writeAdminResponse(response, request, http.StatusOK, adminEndpointEntry{Id: entry.Id, Source: "runtime", Endpoint: entry.Endpoint})
+				return
+			}
+		}
+		writeAdminError(response, request, http.StatusNotFound, errEndpointNotFound)
+	})
+
+	router.PUT(adminPrefix+"endpoints/:id", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		endpoint := EndpointStruct{}
+		if err := readAdminBody(request, &endpoint); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else if err := server.ReplaceEndpoint(id, endpoint); errors.Is(err, errEndpointNotFound) {
+			writeAdminError(response, request, http.StatusNotFound, err)
+		} else if err != nil {
+			writeAdminError(response, request, http.StatusConflict, err)
+		} else {
+			writeAdminResponse(response, request, http.StatusOK, adminEndpointEntry{Id: id, Source: "runtime", Endpoint: endpoint})
+		}
+	})
+
+	router.DELETE(adminPrefix+"endpoints/:id", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if err := server.DeleteEndpoint(params.ByName("id")); errors.Is(err, errEndpointNotFound) {
+			writeAdminError(response, request, http.StatusNotFound, err)
+		} else if err != nil {
+			writeAdminError(response, request, http.StatusInternalServerError, err)
+		} else {
+			response.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testAdminEndpointsConfig = `
+endpoints:
+  - url: /config
+    method: GET
+    actions:
+      - type: response
+        params: {body: config}
+`
+
+func TestAdminEndpointsRoundTrip(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testAdminEndpointsConfig})
+
+	status, body := serveTest(server, http.MethodPost, "/__admin/endpoints",
+		`{"url": "/runtime", "method": "GET", "actions": [{"type": "response", "params": {"body": "first"}}]}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create: got %d %s", status, body)
+	}
+	// admin responses are decoded like admin requests
+	created := adminEndpointEntry{}
+	if err := yaml.Unmarshal([]byte(body), &created); err != nil || created.Id == "" {
+		t.Fatalf("create: invalid entry %s: %v", body, err)
+	}
+	if _, body := serveTest(server, http.MethodGet, "/runtime", "", nil); body != "first" {
+		t.Errorf("runtime endpoint answers %q, want first", body)
+	}
+
+	_, body = serveTest(server, http.MethodGet, "/__admin/endpoints", "", nil)
+	entries := []adminEndpointEntry{}
+	if err := yaml.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Source != "config" || entries[1].Id != created.Id {
+		t.Errorf("unexpected endpoint list %s", body)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/__admin/endpoints/"+created.Id, "", nil); status != http.StatusOK {
+		t.Errorf("get: got %d", status)
+	}
+
+	status, body = serveTest(server, http.MethodPut, "/__admin/endpoints/"+created.Id,
+		`{"url": "/runtime", "method": "GET", "actions": [{"type": "response", "params": {"body": "second"}}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("replace: got %d %s", status, body)
+	}
+	if _, body := serveTest(server, http.MethodGet, "/runtime", "", nil); body != "second" {
+		t.Errorf("replaced endpoint answers %q, want second", body)
+	}
+
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/endpoints/"+created.Id, "", nil); status != http.StatusNoContent {
+		t.Errorf("delete: got %d", status)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/runtime", "", nil); status != http.StatusNotFound {
+		t.Errorf("deleted endpoint answers %d", status)
+	}
+	if _, body := serveTest(server, http.MethodGet, "/config", "", nil); body != "config" {
+		t.Errorf("config endpoint answers %q", body)
+	}
+}
+
+func TestAdminEndpointsErrors(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testAdminEndpointsConfig})
+	endpoint := `{"url": "/x", "method": "GET", "actions": [{"type": "response", "params": {"body": "x"}}]}`
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"get unknown", http.MethodGet, "/__admin/endpoints/unknown", "", http.StatusNotFound},
+		{"replace unknown", http.MethodPut, "/__admin/endpoints/unknown", endpoint, http.StatusNotFound},
+		{"delete unknown", http.MethodDelete, "/__admin/endpoints/unknown", "", http.StatusNotFound},
+		{"invalid body", http.MethodPost, "/__admin/endpoints", "{", http.StatusBadRequest},
+		{"ambiguous with config", http.MethodPost, "/__admin/endpoints",
+			`{"url": "/config", "method": "GET", "actions": [{"type": "response", "params": {"body": "runtime"}}]}`, http.StatusConflict},
+		{"invalid action", http.MethodPost, "/__admin/endpoints",
+			`{"url": "/broken", "method": "GET", "actions": [{"type": "unknown"}]}`, http.StatusConflict},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status, body := serveTest(server, test.method, test.url, test.body, nil); status != test.status {
+				t.Errorf("got %d %s, want %d", status, body, test.status)
+			}
+		})
+	}
+
+	// rejected changes leave the served endpoints untouched
+	if _, body := serveTest(server, http.MethodGet, "/config", "", nil); body != "config" {
+		t.Errorf("config endpoint answers %q", body)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/broken", "", nil); status != http.StatusNotFound {
+		t.Errorf("rejected endpoint answers %d", status)
+	}
+}
+
+func TestAdminEndpointsAtomicUnderTraffic(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testAdminEndpointsConfig})
+	id, err := server.AddEndpoint(EndpointStruct{
+		Url:     "/runtime",
+		Method:  Methods{http.MethodGet},
+		Actions: []ActionStruct{{Type: "response", Params: map[string]interface{}{"body": "v0"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// every request is served by either the old or the new router
+				if status, body := serveTest(server, http.MethodGet, "/runtime", "", nil); status != http.StatusOK || (body != "v0" && body != "v1") {
+					t.Errorf("got %d %q during a replacement", status, body)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		body := []string{"v0", "v1"}[i%2]
+		status, response := serveTest(server, http.MethodPut, "/__admin/endpoints/"+id,
+			`{"url": "/runtime", "method": "GET", "actions": [{"type": "response", "params": {"body": "`+body+`"}}]}`, nil)
+		if status != http.StatusOK {
+			t.Fatalf("replace: got %d %s", status, response)
+		}
+	}
+	close(done)
+	wait.Wait()
+}
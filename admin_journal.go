@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	adminRouteProviders["requests"] = newAdminJournalRoutes
+}
+
+// journalFilterFromQuery reads a journal filter from the query parameters of
+// request. Headers are given as repeated `header=<name>:<pattern>` params.
+func journalFilterFromQuery(query url.Values) (*journalFilter, error) {
+	filter := &journalFilter{
+		Method:      query.Get("method"),
+		Url:         query.Get("url"),
+		UrlPattern:  query.Get("urlPattern"),
+		Endpoint:    query.Get("endpoint"),
+		BodyPattern: query.Get("bodyPattern"),
+		Headers:     make(map[string]string),
+	}
+	for _, header := range query["header"] {
+		if name, pattern, found := strings.Cut(header, ":"); !found {
+			return nil, fmt.Errorf("invalid header filter '%s', expected <name>:<pattern>", header)
+		} else {
+			filter.Headers[name] = pattern
+		}
+	}
+	if status := query.Get("status"); status != "" {
+		var err error
+		if filter.Status, err = strconv.Atoi(status); err != nil {
+			return nil, fmt.Errorf("invalid status filter '%s'", status)
+		}
+	}
+	return filter, filter.compile()
+}
+
+// journalVerification describes the expected number of requests matching the
+// embedded filter. Without any count constraint at least one is expected.
+type journalVerification struct {
+	journalFilter `yaml:",inline"`
+	Count         *int `json:"count" yaml:"count"`
+	AtLeast       *int `json:"atLeast" yaml:"atLeast"`
+	AtMost        *int `json:"atMost" yaml:"atMost"`
+}
+
+func (verification *journalVerification) check(count int) error {
+	switch {
+	case verification.Count != nil && count != *verification.Count:
+		return fmt.Errorf("expected exactly %d matching requests, got %d", *verification.Count, count)
+	case verification.AtLeast != nil && count < *verification.AtLeast:
+		return fmt.Errorf("expected at least %d matching requests, got %d", *verification.AtLeast, count)
+	case verification.AtMost != nil && count > *verification.AtMost:
+		return fmt.Errorf("expected at most %d matching requests, got %d", *verification.AtMost, count)
+	case verification.Count == nil && verification.AtLeast == nil && verification.AtMost == nil && count == 0:
+		return fmt.Errorf("expected at least 1 matching request, got 0")
+	}
+	return nil
+}
+
+func newAdminJournalRoutes(router *httprouter.Router, server *dummyServer) {
+	router.GET(adminPrefix+"requests", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		if filter, err := journalFilterFromQuery(request.URL.Query()); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else {
+			writeAdminResponse(response, request, http.StatusOK, server.journal.Find(filter))
+		}
+	})
+
+	router.POST(adminPrefix+"requests/verify", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		verification := &journalVerification{}
+		if err := readAdminBody(request, verification); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else if err := verification.compile(); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else {
+			entries := server.journal.Find(&verification.journalFilter)
+			result := map[string]any{"count": len(entries), "requests": entries}
+			if err := verification.check(len(entries)); err != nil {
+				result["error"] = err.Error()
+				writeAdminResponse(response, request, http.StatusExpectationFailed, result)
+			} else {
+				writeAdminResponse(response, request, http.StatusOK, result)
+			}
+		}
+	})
+
+	router.DELETE(adminPrefix+"requests", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		server.journal.Clear()
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	router.GET(adminPrefix+"requests/:id", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		// httprouter does not allow a static `count` segment next to `:id`
+		if params.ByName("id") == "count" {
+			if filter, err := journalFilterFromQuery(request.URL.Query()); err != nil {
+				writeAdminError(response, request, http.StatusBadRequest, err)
+			} else {
+				writeAdminResponse(response, request, http.StatusOK, map[string]int{"count": len(server.journal.Find(filter))})
+			}
+		} else if entry := server.journal.Get(params.ByName("id")); entry == nil {
+			writeAdminError(response, request, http.StatusNotFound, fmt.Errorf("request not found"))
+		} else {
+			writeAdminResponse(response, request, http.StatusOK, entry)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	adminRouteProviders["scenarios"] = newAdminScenarioRoutes
+}
+
+func newAdminScenarioRoutes(router *httprouter.Router, server *dummyServer) {
+	// only scenarios that left their initial state are listed
+	router.GET(adminPrefix+"scenarios", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		writeAdminResponse(response, request, http.StatusOK, scenarios.States())
+	})
+
+	router.DELETE(adminPrefix+"scenarios", func(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		if query := request.URL.Query(); query.Has("namespace") {
+			scenarios.ResetNamespace(query.Get("namespace"))
+			responseCounters.ResetNamespace(query.Get("namespace"))
+		} else {
+			scenarios.ResetAll()
+			responseCounters.ResetAll()
+		}
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	router.GET(adminPrefix+"scenarios/:name", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if key, err := adminScenarioKey(request, params); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else {
+			writeAdminResponse(response, request, http.StatusOK, map[string]string{"state": scenarios.State(key)})
+		}
+	})
+
+	router.PUT(adminPrefix+"scenarios/:name", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		body := struct{ State string }{}
+		if key, err := adminScenarioKey(request, params); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else if err := readAdminBody(request, &body); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else if body.State == "" {
+			writeAdminError(response, request, http.StatusBadRequest, fmt.Errorf("missing state"))
+		} else {
+			scenarios.Transition(key, "", body.State)
+			response.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	router.DELETE(adminPrefix+"scenarios/:name", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if key, err := adminScenarioKey(request, params); err != nil {
+			writeAdminError(response, request, http.StatusBadRequest, err)
+		} else {
+			scenarios.Reset(key)
+			responseCounters.Reset(key)
+			response.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// adminScenarioKey returns the key of the scenario named by the route. With a
+// `namespace` query param the name is taken from that namespace, otherwise it
+// is the full `<namespace>::<name>`.
+func adminScenarioKey(request *http.Request, params httprouter.Params) (string, error) {
+	name := params.ByName("name")
+	namespace, namespaced := adminCacheNamespace(request)
+	if !namespaced {
+		return name, nil
+	}
+	if err := validateNamespace(namespace); err != nil {
+		return "", err
+	}
+	if strings.Contains(name, namespaceSeparator) {
+		return "", fmt.Errorf("invalid scenario name '%s': must not contain '%s' next to a namespace", name, namespaceSeparator)
+	}
+	return namespacedKey(namespace, name), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func init() {
+	adminRouteProviders["tls"] = newAdminTLSRoutes
+}
+
+func newAdminTLSRoutes(router *httprouter.Router, server *dummyServer) {
+	router.GET(adminPrefix+"tls/:file", func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if server.tls == nil || server.tls.caCertificate == nil {
+			writeAdminError(response, request, http.StatusNotFound,
+				fmt.Errorf("no generated CA certificate, enable server.tls.selfSigned"))
+			return
+		}
+		switch params.ByName("file") {
+		case "ca.pem":
+			response.Header().Set("Content-Type", "application/x-pem-file")
+			response.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.tls.caCertificate.Raw}))
+		case "ca.der", "ca.crt":
+			// DER is what most mobile platforms expect when installing a CA
+			response.Header().Set("Content-Type", "application/x-x509-ca-cert")
+			response.Write(server.tls.caCertificate.Raw)
+		default:
+			writeAdminError(response, request, http.StatusNotFound,
+				fmt.Errorf("unknown file, use ca.pem or ca.der"))
+		}
+	})
+}
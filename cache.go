@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,76 +31,231 @@ func init() {
 type Cache interface {
 	Add(key string, value any, timeout time.Duration)
 	Get(key string, defaultValue any) any
+	// Delete removes key and reports whether it existed.
+	Delete(key string) bool
+	// Update atomically replaces the value of key by the result of update,
+	// unless it returns false. Existing entries keep their expiry, new ones
+	// expire after timeout. Update reports whether the value was changed.
+	Update(key string, timeout time.Duration, update func(value any, exists bool) (any, bool)) bool
 	ToMap() map[string]any
+	// Entries returns a snapshot of all entries including their expiry.
+	Entries() map[string]CacheEntry
 	Clear()
+	// Subscribe registers listener for all changes of the cache until the
+	// returned function is called. Listeners must not block.
+	Subscribe(listener func(change CacheChange)) (unsubscribe func())
+	// Version is increased by every change of the cache.
+	Version() uint64
+	// Close releases the cache on shutdown. In-memory caches are cleared,
+	// persistent ones write their final snapshot.
+	Close() error
+}
+
+type CacheEntry struct {
+	Value any
+	// zero if the entry does not expire
+	Expires time.Time
+}
+
+// CacheChange describes a changed or removed key.
+type CacheChange struct {
+	Key     string
+	Value   any
+	Deleted bool
 }
 
 func NewCache(additionalRemovalAction func(key string, value any)) Cache {
 	return &cacheImpl{
-		internalMap: make(map[string]any),
-		timersLock: &sync.Mutex{},
-		timers: make(map[string]*time.Timer),
+		entries: make(map[string]*cacheEntry),
 		additionalRemovalAction: additionalRemovalAction,
+		listeners: make(map[int]func(change CacheChange)),
 	}
 }
 
+// cacheImpl guards all entries with a single lock. Removal actions are run
+// after the lock has been released, as they may be slow (e.g. file removal).
 type cacheImpl struct {
-	internalMap map[string]any
-	timersLock *sync.Mutex
-	timers map[string]*time.Timer
+	lock sync.RWMutex
+	entries map[string]*cacheEntry
 	additionalRemovalAction func(key string, value any)
+
+	listenersLock sync.RWMutex
+	listeners     map[int]func(change CacheChange)
+	nextListener  int
+	version       atomic.Uint64
 }
 
-func (cache *cacheImpl) resetRemovalTimer(key string, timeout time.Duration) {
-	cache.timersLock.Lock()
-	defer cache.timersLock.Unlock()
-	timer, exists := cache.timers[key]
-	if exists {
-		timer.Reset(timeout)
-	} else {
-		timer := time.AfterFunc(timeout, func() {
-			cache.timersLock.Lock()
-			defer cache.timersLock.Unlock()
-			if _, exists := cache.timers[key]; !exists {
-				return
-			}
-			value := cache.internalMap[key]
-			delete(cache.timers, key)
-			delete(cache.internalMap, key)
-			cache.additionalRemovalAction(key, value)
-		})
-		cache.timers[key] = timer
+type cacheEntry struct {
+	value any
+	// expiry timer, nil if the entry does not expire
+	timer   *time.Timer
+	expires time.Time
+}
+
+// expire removes entry if it is still the one stored for key, i.e. it has not
+// been replaced since its timer was started.
+func (cache *cacheImpl) expire(key string, entry *cacheEntry) {
+	cache.lock.Lock()
+	if cache.entries[key] != entry {
+		cache.lock.Unlock()
+		return
 	}
+	delete(cache.entries, key)
+	cache.lock.Unlock()
+	cache.additionalRemovalAction(key, entry.value)
+	cache.notify(CacheChange{Key: key, Value: entry.value, Deleted: true})
 }
 
+// Add stores value under key. A replaced value is removed like an expired one.
 func (cache *cacheImpl) Add(key string, value any, timeout time.Duration) {
-	cache.internalMap[key] = value
+	entry := &cacheEntry{value: value}
+	cache.lock.Lock()
+	previous, replaced := cache.entries[key]
+	if replaced && previous.timer != nil {
+		previous.timer.Stop()
+	}
+	if timeout > 0 {
+		entry.expires = time.Now().Add(timeout)
+		entry.timer = time.AfterFunc(timeout, func() {
+			cache.expire(key, entry)
+		})
+	}
+	cache.entries[key] = entry
+	cache.lock.Unlock()
+	if replaced {
+		cache.additionalRemovalAction(key, previous.value)
+	}
+	cache.notify(CacheChange{Key: key, Value: value})
+}
+
+func (cache *cacheImpl) Update(key string, timeout time.Duration, update func(value any, exists bool) (any, bool)) bool {
+	value, updated := cache.update(key, timeout, update)
+	if updated {
+		cache.notify(CacheChange{Key: key, Value: value})
+	}
+	return updated
+}
+
+func (cache *cacheImpl) update(key string, timeout time.Duration, update func(value any, exists bool) (any, bool)) (any, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, exists := cache.entries[key]
+	var value any
+	if exists {
+		value = entry.value
+	}
+	value, ok := update(value, exists)
+	if !ok {
+		return nil, false
+	}
+	if exists {
+		// keeps the running expiry timer valid
+		entry.value = value
+		return value, true
+	}
+	entry = &cacheEntry{value: value}
 	if timeout > 0 {
-		cache.resetRemovalTimer(key, timeout)
+		entry.expires = time.Now().Add(timeout)
+		entry.timer = time.AfterFunc(timeout, func() {
+			cache.expire(key, entry)
+		})
 	}
+	cache.entries[key] = entry
+	return value, true
 }
 
 func (cache *cacheImpl) Get(key string, defaultValue any) any {
-	if value, exists := cache.internalMap[key]; exists {
-		return value
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if entry, exists := cache.entries[key]; exists {
+		return entry.value
 	}
 	return defaultValue
 }
 
+func (cache *cacheImpl) Delete(key string) bool {
+	cache.lock.Lock()
+	entry, exists := cache.entries[key]
+	if exists {
+		delete(cache.entries, key)
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+	}
+	cache.lock.Unlock()
+	if exists {
+		cache.additionalRemovalAction(key, entry.value)
+		cache.notify(CacheChange{Key: key, Value: entry.value, Deleted: true})
+	}
+	return exists
+}
+
+// ToMap returns a snapshot of the cache, later changes are not reflected.
 func (cache *cacheImpl) ToMap() map[string]any {
-	return cache.internalMap
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	snapshot := make(map[string]any, len(cache.entries))
+	for key, entry := range cache.entries {
+		snapshot[key] = entry.value
+	}
+	return snapshot
+}
+
+func (cache *cacheImpl) Entries() map[string]CacheEntry {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	snapshot := make(map[string]CacheEntry, len(cache.entries))
+	for key, entry := range cache.entries {
+		snapshot[key] = CacheEntry{Value: entry.value, Expires: entry.expires}
+	}
+	return snapshot
 }
 
 func (cache *cacheImpl) Clear() {
-	cache.timersLock.Lock()
-	defer cache.timersLock.Unlock()
-	for key, value := range cache.internalMap {
-		delete(cache.internalMap, key)
-		delete(cache.timers, key)
-		cache.additionalRemovalAction(key, value)
+	cache.lock.Lock()
+	entries := cache.entries
+	cache.entries = make(map[string]*cacheEntry)
+	cache.lock.Unlock()
+	for key, entry := range entries {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+		cache.additionalRemovalAction(key, entry.value)
+		cache.notify(CacheChange{Key: key, Value: entry.value, Deleted: true})
+	}
+}
+
+func (cache *cacheImpl) Subscribe(listener func(change CacheChange)) func() {
+	cache.listenersLock.Lock()
+	defer cache.listenersLock.Unlock()
+	id := cache.nextListener
+	cache.nextListener++
+	cache.listeners[id] = listener
+	return func() {
+		cache.listenersLock.Lock()
+		defer cache.listenersLock.Unlock()
+		delete(cache.listeners, id)
 	}
 }
 
+func (cache *cacheImpl) Version() uint64 {
+	return cache.version.Load()
+}
+
+func (cache *cacheImpl) notify(change CacheChange) {
+	cache.version.Add(1)
+	cache.listenersLock.RLock()
+	defer cache.listenersLock.RUnlock()
+	for _, listener := range cache.listeners {
+		listener(change)
+	}
+}
+
+func (cache *cacheImpl) Close() error {
+	cache.Clear()
+	return nil
+}
+
 type CacheFile interface {
 	Remove() error
 	AddHeaders(response http.ResponseWriter)
@@ -112,9 +268,15 @@ type cacheFileImpl struct {
 	Headers map[string][]string
 }
 
-func NewCacheFile(file multipart.File, header *multipart.FileHeader) (cacheFile *cacheFileImpl, cacheErr error) {
+func NewCacheFile(file multipart.File, header *multipart.FileHeader) (*cacheFileImpl, error) {
+	return NewCacheFileFromReader(file, header.Header)
+}
+
+// NewCacheFileFromReader copies the content of reader into a new file below the cache
+// path.
+func NewCacheFileFromReader(reader io.Reader, headers map[string][]string) (cacheFile *cacheFileImpl, cacheErr error) {
 	cacheFile = &cacheFileImpl{
-		Headers: header.Header,
+		Headers: headers,
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -144,7 +306,7 @@ func NewCacheFile(file multipart.File, header *multipart.FileHeader) (cacheFile
 		panic(err)
 	}
 	cacheFile.tmpFile = tmpFile
-	if _, err := io.Copy(cacheFile.tmpFile, file); err != nil {
+	if _, err := io.Copy(cacheFile.tmpFile, reader); err != nil {
 		panic(err)
 	}
 	cacheFile.tmpFile.Sync()
@@ -171,3 +333,99 @@ func (cacheFile *cacheFileImpl) Copy(response http.ResponseWriter) error {
 		return err
 	}
 }
+
+// Keys of the global context are scoped by namespaces, so that parallel test
+// runs do not overwrite each other's state. The default namespace is empty.
+// Namespaces and keys must not contain the separator, and namespaces must not
+// end with ':', so that every stored key belongs to exactly one namespace.
+const namespaceSeparator = "::"
+
+// namespacedKey returns the key of the global context for key in namespace.
+// It panics if namespace or key would make the stored key ambiguous.
+func namespacedKey(namespace string, key string) string {
+	if err := validateNamespace(namespace); err != nil {
+		panic(err)
+	}
+	if strings.Contains(key, namespaceSeparator) {
+		panic(fmt.Errorf("invalid key '%s': must not contain '%s'", key, namespaceSeparator))
+	}
+	if namespace == "" {
+		return key
+	}
+	return namespace + namespaceSeparator + key
+}
+
+func validateNamespace(namespace string) error {
+	if strings.Contains(namespace, namespaceSeparator) || strings.HasSuffix(namespace, ":") {
+		return fmt.Errorf("invalid namespace '%s': must not contain '%s' or end with ':'", namespace, namespaceSeparator)
+	}
+	return nil
+}
+
+// inNamespace reports whether key belongs to namespace and returns it without
+// the namespace prefix. No key belongs to an invalid namespace.
+func inNamespace(namespace string, key string) (string, bool) {
+	if validateNamespace(namespace) != nil {
+		return "", false
+	}
+	if namespace == "" {
+		return key, !strings.Contains(key, namespaceSeparator)
+	}
+	return strings.CutPrefix(key, namespace+namespaceSeparator)
+}
+
+// namespaceMap returns a snapshot of the values of namespace in cache.
+func namespaceMap(cache Cache, namespace string) map[string]any {
+	values := make(map[string]any)
+	for key, value := range cache.ToMap() {
+		if key, ok := inNamespace(namespace, key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// globalSnapshot provides the `__global__` values of a namespace. They are
+// only rebuilt if the global context changed since the last call, e.g. by an
+// earlier action of the request.
+type globalSnapshot struct {
+	namespace string
+	version   uint64
+	values    map[string]any
+}
+
+func newGlobalSnapshot(namespace string) *globalSnapshot {
+	return &globalSnapshot{namespace: namespace}
+}
+
+func (snapshot *globalSnapshot) get() map[string]any {
+	// read before the values, so that concurrent changes trigger a rebuild
+	if version := globalContext.Version(); snapshot.values == nil || version != snapshot.version {
+		snapshot.version, snapshot.values = version, namespaceMap(globalContext, snapshot.namespace)
+	}
+	return snapshot.values
+}
+
+// contextNamespace resolves the namespace configured for an action, which
+// defaults to the namespace of its endpoint.
+func contextNamespace(namespace string, context map[string]any) string {
+	if namespace != "" {
+		return fromTemplate(namespace, context)
+	}
+	if namespace, ok := context["__namespace__"].(string); ok {
+		return namespace
+	}
+	return ""
+}
+
+// resolveCacheValue returns the value configured for a cache action, either
+// the context value at `path` or the templated `value`.
+func resolveCacheValue(path string, value any, context map[string]any) (any, error) {
+	if path != "" {
+		return (&PathAccessor{context}).Must(fromTemplate(path, context))
+	}
+	if template, ok := value.(string); ok {
+		return fromTemplate(template, context), nil
+	}
+	return value, nil
+}
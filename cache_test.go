@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	cache := NewCache(func(key string, value any) {})
+	var notified atomic.Int64
+	unsubscribe := cache.Subscribe(func(change CacheChange) {
+		notified.Add(1)
+	})
+	defer unsubscribe()
+
+	const workers, iterations = 8, 200
+	var wait sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func(worker int) {
+			defer wait.Done()
+			key := fmt.Sprintf("key-%d", worker)
+			for i := 0; i < iterations; i++ {
+				cache.Add(key, i, time.Minute)
+				cache.Get(key, nil)
+				cache.Update("counter", 0, func(value any, exists bool) (any, bool) {
+					count, _ := value.(int)
+					return count + 1, true
+				})
+				cache.Entries()
+				cache.ToMap()
+				cancel := cache.Subscribe(func(CacheChange) {})
+				cache.Delete(key)
+				cancel()
+			}
+		}(worker)
+	}
+	wait.Wait()
+
+	if count := cache.Get("counter", nil); count != workers*iterations {
+		t.Errorf("counter is %v, want %d", count, workers*iterations)
+	}
+	// every Add, Update and Delete is notified
+	if count := notified.Load(); count != 3*workers*iterations {
+		t.Errorf("%d changes notified, want %d", count, 3*workers*iterations)
+	}
+}
+
+// testCacheItem is a unique cache value which counts its removals.
+type testCacheItem struct {
+	removals atomic.Int32
+	updates  atomic.Int32
+}
+
+// TestCacheConcurrentExpiry races expiry timers with Delete, Clear and Update
+// and checks that every stored value is removed exactly once.
+func TestCacheConcurrentExpiry(t *testing.T) {
+	var created sync.Map
+	cache := NewCache(func(key string, value any) {
+		value.(*testCacheItem).removals.Add(1)
+	})
+	var deleted atomic.Int64
+	unsubscribe := cache.Subscribe(func(change CacheChange) {
+		if change.Deleted {
+			deleted.Add(1)
+		}
+	})
+	defer unsubscribe()
+
+	const workers, iterations = 8, 200
+	var wait sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func(worker int) {
+			defer wait.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("key-%d", i%5)
+				ttl := time.Duration(1+i%3) * time.Millisecond
+				switch i % 7 {
+				case 0, 1, 2:
+					item := &testCacheItem{}
+					created.Store(item, true)
+					cache.Add(key, item, ttl)
+				case 3, 4:
+					cache.Update(key, ttl, func(value any, exists bool) (any, bool) {
+						if !exists {
+							item := &testCacheItem{}
+							created.Store(item, true)
+							return item, true
+						}
+						value.(*testCacheItem).updates.Add(1)
+						return value, true
+					})
+				case 5:
+					cache.Delete(key)
+				case 6:
+					if worker == 0 {
+						cache.Clear()
+					}
+				}
+				cache.Get(key, nil)
+				cache.Entries()
+				cache.ToMap()
+				if i%20 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(worker)
+	}
+	wait.Wait()
+	// lets the remaining timers fire
+	time.Sleep(50 * time.Millisecond)
+	cache.Clear()
+
+	if entries := cache.ToMap(); len(entries) != 0 {
+		t.Errorf("%d entries left", len(entries))
+	}
+	count := 0
+	created.Range(func(item, _ any) bool {
+		count++
+		if removals := item.(*testCacheItem).removals.Load(); removals != 1 {
+			t.Errorf("item removed %d times, want once", removals)
+			return false
+		}
+		return true
+	})
+	// replaced values are removed without a deletion notice
+	if deleted.Load() > int64(count) {
+		t.Errorf("%d deletions notified for %d items", deleted.Load(), count)
+	}
+}
+
+// TestFileCacheConcurrentExpiry races the expiry of cached files with Delete
+// and Clear and checks that every file is removed exactly once.
+func TestFileCacheConcurrentExpiry(t *testing.T) {
+	previousPath := __cache_path__
+	__cache_path__ = t.TempDir()
+	defer func() { __cache_path__ = previousPath }()
+
+	var failedRemovals atomic.Int64
+	cache := NewCache(func(key string, value any) {
+		if err := value.(CacheFile).Remove(); err != nil {
+			failedRemovals.Add(1)
+		}
+	})
+
+	const workers, iterations = 4, 50
+	var wait sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func(worker int) {
+			defer wait.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("file-%d", i%3)
+				file, err := NewCacheFileFromReader(strings.NewReader(key), nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				cache.Add(key, file, time.Duration(1+i%2)*time.Millisecond)
+				switch i % 5 {
+				case 1:
+					cache.Delete(key)
+				case 3:
+					if worker == 0 {
+						cache.Clear()
+					}
+				}
+				cache.Get(key, nil)
+			}
+		}(worker)
+	}
+	wait.Wait()
+	time.Sleep(50 * time.Millisecond)
+
+	if entries := cache.ToMap(); len(entries) != 0 {
+		t.Errorf("%d files did not expire", len(entries))
+	}
+	if failed := failedRemovals.Load(); failed != 0 {
+		t.Errorf("%d files removed more than once", failed)
+	}
+	if files, err := os.ReadDir(__cache_path__); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 0 {
+		t.Errorf("%d files left in the cache path", len(files))
+	}
+}
+
+func TestCacheUpdateKeepsExpiry(t *testing.T) {
+	cache := NewCache(func(key string, value any) {})
+	cache.Add("key", 1, 50*time.Millisecond)
+	cache.Update("key", time.Hour, func(value any, exists bool) (any, bool) {
+		return value.(int) + 1, true
+	})
+	if value := cache.Get("key", nil); value != 2 {
+		t.Fatalf("value is %v, want 2", value)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if value := cache.Get("key", nil); value != nil {
+		t.Errorf("value %v did not expire", value)
+	}
+}
+
+func TestCacheReplaceRunsRemovalAction(t *testing.T) {
+	var removed []any
+	var lock sync.Mutex
+	cache := NewCache(func(key string, value any) {
+		lock.Lock()
+		defer lock.Unlock()
+		removed = append(removed, value)
+	})
+	cache.Add("key", "first", 0)
+	cache.Add("key", "second", 0)
+	cache.Delete("key")
+	if !reflect.DeepEqual(removed, []any{"first", "second"}) {
+		t.Errorf("removed %v, want [first second]", removed)
+	}
+}
+
+func newTestPersistentCache(path string) *persistentCache {
+	return newPersistentCache(path,
+		func(key string, value any) {},
+		func(value any) (any, error) { return value, nil },
+		decodeCacheValue)
+}
+
+func TestPersistentCacheRestoresSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "global.json")
+	cache := newTestPersistentCache(path)
+	values := map[string]any{
+		"int":    3,
+		"float":  1.5,
+		"nested": map[string]any{"list": []any{1, "two", map[string]any{"three": 3}}},
+	}
+	for key, value := range values {
+		cache.Add(key, value, 0)
+	}
+	cache.Add("expiring", true, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestPersistentCache(path)
+	if exists, err := restored.load(); err != nil || !exists {
+		t.Fatalf("load: %v %v", exists, err)
+	}
+	if got := restored.ToMap(); !reflect.DeepEqual(got, values) {
+		t.Errorf("restored %#v, want %#v", got, values)
+	}
+}
+
+func TestPersistentCacheConcurrentChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "global.json")
+	cache := newTestPersistentCache(path)
+
+	const workers, iterations = 8, 100
+	var wait sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func(worker int) {
+			defer wait.Done()
+			for i := 0; i < iterations; i++ {
+				cache.Add(fmt.Sprintf("%d-%d", worker, i), i, 0)
+				cache.Update("counter", 0, func(value any, exists bool) (any, bool) {
+					count, _ := value.(int)
+					return count + 1, true
+				})
+			}
+		}(worker)
+	}
+	wait.Wait()
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestPersistentCache(path)
+	if _, err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if count := len(restored.ToMap()); count != workers*iterations+1 {
+		t.Errorf("restored %d entries, want %d", count, workers*iterations+1)
+	}
+	if count := restored.Get("counter", nil); count != workers*iterations {
+		t.Errorf("counter is %v, want %d", count, workers*iterations)
+	}
+}
+
+func TestNamespacedKeys(t *testing.T) {
+	for _, test := range []struct{ namespace, key string }{
+		{"", "plain"}, {"", "a:b"}, {"run-1", "key"}, {"run-1", ":key"}, {"run-1", "key:"},
+	} {
+		stored := namespacedKey(test.namespace, test.key)
+		for _, namespace := range []string{"", "run-1", "run-1:", "run"} {
+			key, ok := inNamespace(namespace, stored)
+			if want := namespace == test.namespace; ok != want || (ok && key != test.key) {
+				t.Errorf("%q in namespace %q: got %q %v", stored, namespace, key, ok)
+			}
+		}
+	}
+
+	for _, test := range []struct{ namespace, key string }{
+		{"", "a::b"}, {"run-1", "a::b"}, {"a::b", "key"}, {"run-1:", "key"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("namespace %q and key %q were accepted", test.namespace, test.key)
+				}
+			}()
+			namespacedKey(test.namespace, test.key)
+		}()
+	}
+}
+
+func TestNamespaceSeparatorFailsActions(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /store/:key
+    method: PUT
+    namespace: '{{index .__headers__ "X-Test-Id"}}'
+    actions:
+      - type: cache-increment
+        params: {key: '{{.params.key}}'}
+      - type: response
+        params: {body: '{{index .__global__ .params.key}}'}
+`})
+	defer globalContext.Delete(namespacedKey("test-separator", "counter"))
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		status  int
+	}{
+		{"valid", "/store/counter", map[string]string{"X-Test-Id": "test-separator"}, http.StatusOK},
+		{"separator in key", "/store/a::counter", map[string]string{"X-Test-Id": "test-separator"}, http.StatusInternalServerError},
+		{"separator in namespace", "/store/counter", map[string]string{"X-Test-Id": "test::separator"}, http.StatusInternalServerError},
+		{"separator in default namespace", "/store/test-separator::counter", nil, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status, body := serveTest(server, http.MethodPut, test.url, "", test.headers); status != test.status {
+				t.Errorf("got %d %s, want %d", status, body, test.status)
+			}
+		})
+	}
+	if value := globalContext.Get(namespacedKey("test-separator", "counter"), nil); value != 1 {
+		t.Errorf("got counter %v, want 1", value)
+	}
+}
+
+func TestGlobalSnapshotRebuildsAfterChanges(t *testing.T) {
+	key := namespacedKey("test-snapshot", "key")
+	defer globalContext.Delete(key)
+
+	snapshot := newGlobalSnapshot("test-snapshot")
+	first := snapshot.get()
+	if _, exists := first["key"]; exists {
+		t.Fatalf("unexpected value %v", first["key"])
+	}
+	if second := snapshot.get(); reflect.ValueOf(second).Pointer() != reflect.ValueOf(first).Pointer() {
+		t.Error("snapshot was rebuilt without a change")
+	}
+	globalContext.Add(key, "value", 0)
+	if values := snapshot.get(); values["key"] != "value" {
+		t.Errorf("got %v after a change, want value", values["key"])
+	}
+}
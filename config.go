@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// webserver config
+type Config struct {
+	// Additional config files whose endpoints and servers are appended to the
+	// ones of this file. Entries may be glob patterns and are resolved relative
+	// to the directory of the including file.
+	Include []string
+	// Single server serving the top-level endpoints. Kept for compatibility,
+	// after loading it is the first entry of Servers.
+	Server    ServerConfig
+	Endpoints []EndpointStruct
+	Servers   []ServerConfig
+	// Seconds to wait for in-flight requests on shutdown [default=30]
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+	// Persistence of the shared caches, taken from the first server
+	Cache CacheConfig `yaml:"-"`
+
+	// all files read while loading this config (main file first)
+	files []string
+	// config files (including recordings) whose sources make up source
+	sourceFiles []string
+	// concatenated sources of all files, used to diff rejected reloads
+	source string
+	// include glob patterns, whose new matches trigger a reload
+	includeGlobs []string
+}
+
+// ServerConfig describes one listener. All servers share the global cache.
+type ServerConfig struct {
+	// Unique name of the server, defaults to `<ip>:<port>`
+	Name    string
+	Ip      string
+	Port    int
+	Tls     TLSConfig
+	Journal struct {
+		// maximum number of journaled requests, <= 0 keeps all
+		Limit int
+	}
+	Proxy struct {
+		// "record" proxies unmatched requests to the upstream and records
+		// them, "replay" serves the recordings as additional endpoints
+		Mode          string
+		Upstream      string
+		Recordings    string
+		BodyDirectory string `yaml:"bodyDirectory"`
+		// request headers recorded as match predicates
+		MatchHeaders []string `yaml:"matchHeaders"`
+	}
+	// Persistence of the shared caches, only valid for the first server
+	Cache CacheConfig
+	// Status of the error response sent when an action fails [default=500]
+	ErrorStatus int `yaml:"errorStatus"`
+	// Faults injected into all requests to endpoints of this server
+	Fault     *FaultConfig
+	// gRPC listener serving mocked methods of proto services
+	Grpc *GrpcConfig
+	// OpenAPI 3 documents whose operations are served as additional endpoints
+	Openapi []OpenapiImport
+	Endpoints   []EndpointStruct
+	// Endpoints served only for requests to the given hosts; requests to other
+	// hosts are served by Endpoints.
+	VirtualHosts []VirtualHostConfig `yaml:"virtualHosts"`
+}
+
+// endpointLists returns the endpoints of server and of its virtual hosts.
+func (server *ServerConfig) endpointLists() [][]EndpointStruct {
+	lists := [][]EndpointStruct{server.Endpoints}
+	for _, virtualHost := range server.VirtualHosts {
+		lists = append(lists, virtualHost.Endpoints)
+	}
+	return lists
+}
+
+type VirtualHostConfig struct {
+	// host names, `*.example.com` matches all subdomains
+	Hosts     []string
+	Endpoints []EndpointStruct
+}
+
+// loadConfig reads the config file at path and recursively resolves its
+// includes.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	load := newConfigLoad()
+	source := &strings.Builder{}
+	if err := loadConfigFile(path, cfg, load, source, true); err != nil {
+		return nil, err
+	}
+
+	// the legacy server block is the first server if in use
+	if len(cfg.Servers) > 0 && cfg.Server.Port == 0 && len(cfg.Endpoints) > 0 {
+		return nil, fmt.Errorf("top-level endpoints next to servers require a server block with a port")
+	}
+	if len(cfg.Servers) == 0 || cfg.Server.Port != 0 || len(cfg.Endpoints) > 0 {
+		legacy := cfg.Server
+		legacy.Endpoints = append(legacy.Endpoints, cfg.Endpoints...)
+		cfg.Servers = append([]ServerConfig{legacy}, cfg.Servers...)
+	}
+	cfg.Server, cfg.Endpoints = ServerConfig{}, nil
+
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i := range cfg.Servers {
+		server := &cfg.Servers[i]
+		address := fmt.Sprintf("%s:%d", server.Ip, server.Port)
+		if server.Name == "" {
+			server.Name = address
+		}
+		if names[server.Name] {
+			return nil, fmt.Errorf("duplicate server name '%s'", server.Name)
+		}
+		if addresses[address] {
+			return nil, fmt.Errorf("duplicate server address '%s'", address)
+		}
+		names[server.Name], addresses[address] = true, true
+		if server.Cache != (CacheConfig{}) {
+			if i > 0 {
+				return nil, fmt.Errorf("server %s: the shared cache can only be configured on the first server", server.Name)
+			}
+			cfg.Cache = server.Cache
+			if cfg.Cache.Directory != "" {
+				cfg.Cache.Directory = resolveConfigPath(path, cfg.Cache.Directory)
+			}
+			if cfg.Cache.Fixture != "" {
+				cfg.Cache.Fixture = resolveConfigPath(path, cfg.Cache.Fixture)
+			}
+		}
+		if err := resolveServerConfig(path, server, cfg, source); err != nil {
+			return nil, fmt.Errorf("server %s: %w", server.Name, err)
+		}
+	}
+	cfg.source = source.String()
+	return cfg, nil
+}
+
+// resolveServerConfig resolves the paths of server relative to configFile and
+// loads its recordings in replay mode.
+func resolveServerConfig(configFile string, server *ServerConfig, cfg *Config, source *strings.Builder) error {
+	if err := server.Fault.validate(); err != nil {
+		return err
+	}
+	if server.Grpc != nil {
+		// faults are injected into HTTP responses, gRPC calls would silently
+		// be served without them
+		if server.Fault != nil {
+			return fmt.Errorf("server faults are not supported on servers with a grpc listener")
+		}
+		files, err := server.Grpc.resolve(configFile)
+		if err != nil {
+			return err
+		}
+		// watched for reloads like the config files
+		cfg.files = append(cfg.files, files...)
+	}
+	for _, endpoints := range server.endpointLists() {
+		for _, endpoint := range endpoints {
+			if graphql := endpoint.Graphql; graphql != nil && graphql.Schema != "" {
+				graphql.Schema = resolveConfigPath(configFile, graphql.Schema)
+				cfg.files = append(cfg.files, graphql.Schema)
+			}
+			for _, actions := range [][]ActionStruct{endpoint.Actions, endpoint.OnError} {
+				for _, action := range actions {
+					if spec, ok := action.Params["openapi"].(string); ok && action.Type == "validate" && spec != "" {
+						action.Params["openapi"] = resolveConfigPath(configFile, spec)
+						cfg.files = append(cfg.files, action.Params["openapi"].(string))
+					}
+				}
+			}
+		}
+	}
+	for _, openapi := range server.Openapi {
+		if openapi.Spec == "" {
+			return fmt.Errorf("openapi import requires a spec")
+		}
+		spec := resolveConfigPath(configFile, openapi.Spec)
+		endpoints, err := importOpenapi(spec, openapi.Prefix, openapi.Validate, server.Endpoints)
+		if err != nil {
+			return err
+		}
+		cfg.files = append(cfg.files, spec)
+		server.Endpoints = append(server.Endpoints, endpoints...)
+	}
+	if tls := &server.Tls; tls.CertFile != "" {
+		tls.CertFile = resolveConfigPath(configFile, tls.CertFile)
+		tls.KeyFile = resolveConfigPath(configFile, tls.KeyFile)
+	}
+	if proxy := &server.Proxy; proxy.Mode != "" {
+		if proxy.Recordings == "" {
+			proxy.Recordings = "recordings.yaml"
+		}
+		proxy.Recordings = resolveConfigPath(configFile, proxy.Recordings)
+		if proxy.BodyDirectory != "" {
+			proxy.BodyDirectory = resolveConfigPath(configFile, proxy.BodyDirectory)
+		}
+		switch proxy.Mode {
+		case proxyModeRecord:
+			if proxy.Upstream == "" {
+				return fmt.Errorf("proxy mode '%s' requires an upstream", proxy.Mode)
+			}
+		case proxyModeReplay:
+			recordings := &Config{}
+			if err := loadConfigFile(proxy.Recordings, recordings, newConfigLoad(), source, false); err != nil {
+				return err
+			}
+			cfg.files = append(cfg.files, recordings.files...)
+			cfg.sourceFiles = append(cfg.sourceFiles, recordings.sourceFiles...)
+			// only the recordings are served, requests without one are
+			// answered with 404
+			server.Endpoints = recordings.Endpoints
+		default:
+			return fmt.Errorf("unsupported proxy mode '%s'", proxy.Mode)
+		}
+	}
+	return nil
+}
+
+// configLoad tracks the files of one config load: the chain of includes
+// currently being loaded, to detect cycles, and all files loaded so far, so
+// that files included several times are only loaded once.
+type configLoad struct {
+	active map[string]bool
+	loaded map[string]bool
+}
+
+func newConfigLoad() *configLoad {
+	return &configLoad{active: make(map[string]bool), loaded: make(map[string]bool)}
+}
+
+func loadConfigFile(path string, cfg *Config, load *configLoad, source *strings.Builder, isRoot bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if load.active[absPath] {
+		return fmt.Errorf("include cycle detected at '%s'", path)
+	}
+	if load.loaded[absPath] {
+		return nil
+	}
+	load.active[absPath], load.loaded[absPath] = true, true
+	defer delete(load.active, absPath)
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg.files = append(cfg.files, path)
+	cfg.sourceFiles = append(cfg.sourceFiles, path)
+	writeConfigSource(source, path, bytes)
+
+	fileCfg := &Config{}
+	if err := yaml.Unmarshal(bytes, fileCfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if isRoot {
+		cfg.Server = fileCfg.Server
+		cfg.ShutdownTimeout = fileCfg.ShutdownTimeout
+	}
+	cfg.Endpoints = append(cfg.Endpoints, fileCfg.Endpoints...)
+	cfg.Servers = append(cfg.Servers, fileCfg.Servers...)
+
+	for _, include := range fileCfg.Include {
+		include = resolveConfigPath(path, include)
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include '%s': %w", path, include, err)
+		}
+		if !strings.ContainsAny(include, "*?[") {
+			if len(matches) == 0 {
+				return fmt.Errorf("%s: included file '%s' does not exist", path, include)
+			}
+		} else {
+			cfg.includeGlobs = append(cfg.includeGlobs, include)
+		}
+		for _, match := range matches {
+			if err := loadConfigFile(match, cfg, load, source, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeConfigSource(source *strings.Builder, path string, bytes []byte) {
+	fmt.Fprintf(source, "# --- %s\n%s\n", path, bytes)
+}
+
+// readConfigSource returns the current sources of files in the format of
+// Config.source, e.g. to diff a config which cannot be parsed anymore.
+func readConfigSource(files []string) string {
+	source := &strings.Builder{}
+	for _, file := range files {
+		bytes, _ := os.ReadFile(file)
+		writeConfigSource(source, file, bytes)
+	}
+	return source.String()
+}
+
+// watchedFiles returns the files of cfg and the current matches of its
+// include globs, so that new included files are detected.
+func (cfg *Config) watchedFiles() []string {
+	files := append([]string{}, cfg.files...)
+	known := make(map[string]bool, len(files))
+	for _, file := range files {
+		known[file] = true
+	}
+	for _, pattern := range cfg.includeGlobs {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if !known[match] {
+				known[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files
+}
+
+// resolveConfigPath resolves path relative to the directory of configFile.
+func resolveConfigPath(configFile string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configFile), path)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func endpointUrls(endpoints []EndpointStruct) []string {
+	urls := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		urls = append(urls, endpoint.Url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": `
+include: [a.yaml, parts/*.yaml]
+endpoints:
+  - url: /root
+    method: GET
+`,
+		"a.yaml": `
+include: [shared.yaml]
+endpoints:
+  - url: /a
+    method: GET
+`,
+		"parts/b.yaml": `
+include: [../shared.yaml]
+endpoints:
+  - url: /b
+    method: GET
+`,
+		"shared.yaml": `
+endpoints:
+  - url: /shared
+    method: GET
+`,
+	})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shared file is included twice, but loaded once
+	urls := endpointUrls(cfg.Servers[0].Endpoints)
+	if want := []string{"/a", "/b", "/root", "/shared"}; strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("endpoints %v, want %v", urls, want)
+	}
+	if len(cfg.files) != 4 {
+		t.Errorf("files %v, want 4", cfg.files)
+	}
+}
+
+func TestConfigIncludeCycle(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": "include: [a.yaml]\n",
+		"a.yaml":      "include: [b.yaml]\n",
+		"b.yaml":      "include: [a.yaml]\n",
+	})
+	_, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("got error %v, want an include cycle", err)
+	}
+}
+
+func TestConfigMissingInclude(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": "include: [missing.yaml, optional/*.yaml]\n",
+	})
+	_, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got error %v, want a missing include", err)
+	}
+}
+
+func TestConfigEndpointsWithoutServer(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+servers:
+  - port: 8081
+endpoints:
+  - url: /orphan
+    method: GET
+`})
+	_, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "require a server block") {
+		t.Errorf("got error %v, want top-level endpoints to be rejected", err)
+	}
+}
+
+func TestConfigWatchesNewGlobMatches(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": "include: [parts/*.yaml]\n",
+		"parts/a.yaml": `
+endpoints:
+  - url: /a
+    method: GET
+`,
+	})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := filepath.Join(dir, "parts", "b.yaml")
+	if err := os.WriteFile(added, []byte("endpoints: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := cfg.watchedFiles()
+	if len(watched) != 3 || watched[2] != added {
+		t.Errorf("watched files %v, want the new match %s", watched, added)
+	}
+}
+
+func TestConfigResolvesPathsRelativeToConfig(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"config.yaml": `
+endpoints:
+  - url: /graphql
+    method: [GET, POST]
+    graphql:
+      schema: schema.graphql
+      resolvers:
+        Query.hello:
+          value: world
+`,
+		"schema.graphql": "type Query { hello: String }\n",
+	})
+
+	status, body := serveTest(server, http.MethodGet, "/graphql?query=%7Bhello%7D", "", nil)
+	if status != http.StatusOK || body != `{"data":{"hello":"world"}}` {
+		t.Errorf("got %d %s", status, body)
+	}
+}
+
+func TestConfigResolvesValidateOpenapi(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": `
+endpoints:
+  - url: /pets
+    method: GET
+    actions:
+      - type: validate
+        params: {openapi: api/pets.yaml}
+      - type: response
+        params: {body: pets}
+`,
+		"api/pets.yaml": `
+openapi: 3.0.0
+paths:
+  /pets:
+    get:
+      parameters:
+        - {name: limit, in: query, required: true, schema: {type: integer}}
+`,
+	})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := filepath.Join(dir, "api", "pets.yaml")
+	if watched := cfg.watchedFiles(); len(watched) != 2 || watched[1] != spec {
+		t.Errorf("watched files %v, want %s", watched, spec)
+	}
+
+	server, err := newDummyServer(&cfg.Servers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := serveTest(server, http.MethodGet, "/pets?limit=3", "", nil); status != http.StatusOK || body != "pets" {
+		t.Errorf("valid request: got %d %s", status, body)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/pets?limit=x", "", nil); status != http.StatusBadRequest {
+		t.Errorf("invalid request: got %d, want 400", status)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FaultConfig describes faults injected into responses to test the
+// resilience of clients. It is configured per server and per response action.
+type FaultConfig struct {
+	// probability of injecting the faults into a request [default=1]
+	Probability *float64 `json:"probability,omitempty" yaml:",omitempty"`
+	// random delay before the response
+	Latency *LatencyConfig `json:"latency,omitempty" yaml:",omitempty"`
+	// close the connection without responding
+	Drop bool `json:"drop,omitempty" yaml:",omitempty"`
+	// respond with an error instead
+	Error *struct {
+		Status int    `json:"status,omitempty" yaml:",omitempty"`
+		Body   string `json:"body,omitempty" yaml:",omitempty"`
+	} `json:"error,omitempty" yaml:",omitempty"`
+	// fraction of the body sent before closing the connection, 0 sends all
+	Truncate float64 `json:"truncate,omitempty" yaml:",omitempty"`
+	// replace the body by random bytes
+	Malformed bool `json:"malformed,omitempty" yaml:",omitempty"`
+	// throttle the body to bytes per second, 0 is unlimited
+	Bandwidth int `json:"bandwidth,omitempty" yaml:",omitempty"`
+}
+
+// LatencyConfig is a random delay in milliseconds.
+type LatencyConfig struct {
+	// uniform (min, max), normal (mean, stdDev) or lognormal (median, sigma)
+	Distribution string  `json:"distribution" yaml:"distribution"`
+	Min          float64 `json:"min,omitempty" yaml:",omitempty"`
+	Max          float64 `json:"max,omitempty" yaml:",omitempty"`
+	Mean         float64 `json:"mean,omitempty" yaml:",omitempty"`
+	StdDev       float64 `json:"stdDev,omitempty" yaml:"stdDev,omitempty"`
+	Median       float64 `json:"median,omitempty" yaml:",omitempty"`
+	Sigma        float64 `json:"sigma,omitempty" yaml:",omitempty"`
+}
+
+// decodeFaultConfig reads the fault config of an action from its params.
+func decodeFaultConfig(params any) (*FaultConfig, error) {
+	if params == nil {
+		return nil, nil
+	}
+	bytes, err := yaml.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	fault := &FaultConfig{}
+	if err := yaml.Unmarshal(bytes, fault); err != nil {
+		return nil, err
+	}
+	return fault, fault.validate()
+}
+
+func (fault *FaultConfig) validate() error {
+	if fault == nil {
+		return nil
+	}
+	if p := fault.Probability; p != nil && (*p < 0 || *p > 1) {
+		return fmt.Errorf("fault probability must be between 0 and 1")
+	}
+	if fault.Truncate < 0 || fault.Truncate >= 1 {
+		return fmt.Errorf("fault truncate must be a fraction of the body below 1")
+	}
+	if fault.Bandwidth < 0 {
+		return fmt.Errorf("fault bandwidth must not be negative")
+	}
+	if latency := fault.Latency; latency != nil {
+		switch latency.Distribution {
+		case "uniform":
+			if latency.Max < latency.Min {
+				return fmt.Errorf("uniform latency requires min <= max")
+			}
+		case "normal", "lognormal":
+		default:
+			return fmt.Errorf("unsupported latency distribution '%s', use uniform, normal or lognormal", latency.Distribution)
+		}
+	}
+	return nil
+}
+
+// sample returns a random delay of the distribution, never negative and
+// capped at max, if given.
+func (latency *LatencyConfig) sample() time.Duration {
+	var ms float64
+	switch latency.Distribution {
+	case "uniform":
+		ms = latency.Min + rand.Float64()*(latency.Max-latency.Min)
+	case "normal":
+		ms = latency.Mean + rand.NormFloat64()*latency.StdDev
+	case "lognormal":
+		ms = latency.Median * math.Exp(rand.NormFloat64()*latency.Sigma)
+	}
+	if latency.Max > 0 {
+		ms = math.Min(ms, latency.Max)
+	}
+	return time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+}
+
+// sleep waits for d unless done is closed first, e.g. because the client went
+// away. It reports whether the whole duration has passed.
+func sleep(d time.Duration, done <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// applyFault injects fault into a request. If the request has been answered
+// by the fault already, answered is true. Otherwise the response has to be
+// written to writer, if not nil, which must be finished afterwards.
+func applyFault(fault *FaultConfig, requestId string, response http.ResponseWriter, request *http.Request) (writer *faultWriter, answered bool) {
+	if fault == nil || (fault.Probability != nil && rand.Float64() >= *fault.Probability) {
+		return nil, false
+	}
+	if fault.Latency != nil {
+		delay := fault.Latency.sample()
+		log.Printf("[%s] fault: latency %s", requestId, delay)
+		if !sleep(delay, request.Context().Done()) {
+			return nil, true
+		}
+	}
+	if fault.Drop {
+		log.Printf("[%s] fault: dropping connection", requestId)
+		journalFault(request, "dropped connection")
+		if conn, _, err := http.NewResponseController(response).Hijack(); err == nil {
+			conn.Close()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	if fault.Error != nil {
+		status := fault.Error.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		log.Printf("[%s] fault: error %d", requestId, status)
+		journalFault(request, fmt.Sprintf("error %d", status))
+		response.WriteHeader(status)
+		response.Write([]byte(fault.Error.Body))
+		return nil, true
+	}
+	if fault.Truncate > 0 || fault.Malformed || fault.Bandwidth > 0 {
+		return &faultWriter{ResponseWriter: response, fault: fault, request: request}, false
+	}
+	return nil, false
+}
+
+func journalFault(request *http.Request, description string) {
+	if entry := journalEntryOf(request); entry != nil {
+		entry.Error = "fault: " + description
+	}
+}
+
+// faultWriter buffers the response, so that it can be malformed and
+// truncated, and throttles it when it is finished. Once flushed, e.g. by a
+// streaming response, the response is written through and only throttled.
+type faultWriter struct {
+	http.ResponseWriter
+	fault   *FaultConfig
+	request *http.Request
+	status  int
+	body    bytes.Buffer
+	flushed bool
+}
+
+func (writer *faultWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+}
+
+// Started reports whether the response has been flushed, a buffered response
+// may still be replaced by an error response, see discard.
+func (writer *faultWriter) Started() bool {
+	return writer.flushed
+}
+
+// discard drops the buffered status and body of a response which has not been
+// flushed yet.
+func (writer *faultWriter) discard() {
+	if !writer.flushed {
+		writer.status = 0
+		writer.body.Reset()
+	}
+}
+
+func (writer *faultWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	if writer.flushed {
+		if err := writer.writeThrottled(writer.malform(data)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	return writer.body.Write(data)
+}
+
+func (writer *faultWriter) Flush() {
+	if !writer.flushed {
+		writer.flushed = true
+		if writer.status != 0 {
+			writer.ResponseWriter.WriteHeader(writer.status)
+		}
+		writer.writeThrottled(writer.malform(writer.body.Bytes()))
+		writer.body.Reset()
+	}
+	http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+func (writer *faultWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(writer.ResponseWriter).Hijack()
+}
+
+func (writer *faultWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// finish sends the buffered response. A truncated response announces the
+// full length and closes the connection after the configured fraction.
+func (writer *faultWriter) finish() {
+	if writer.flushed || writer.status == 0 {
+		return
+	}
+	body := writer.malform(writer.body.Bytes())
+	if writer.fault.Malformed {
+		journalFault(writer.request, "malformed body")
+	}
+	truncated := writer.fault.Truncate > 0
+	if truncated {
+		writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		body = body[:int(float64(len(body))*writer.fault.Truncate)]
+		journalFault(writer.request, "truncated body")
+	}
+	writer.ResponseWriter.WriteHeader(writer.status)
+	writer.writeThrottled(body)
+	if truncated {
+		http.NewResponseController(writer.ResponseWriter).Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (writer *faultWriter) malform(data []byte) []byte {
+	if !writer.fault.Malformed || len(data) == 0 {
+		return data
+	}
+	garbage := make([]byte, len(data))
+	rand.Read(garbage)
+	return garbage
+}
+
+// writeThrottled writes data in chunks, so that it is sent at the configured
+// bandwidth.
+func (writer *faultWriter) writeThrottled(data []byte) error {
+	bandwidth := writer.fault.Bandwidth
+	if bandwidth <= 0 {
+		_, err := writer.ResponseWriter.Write(data)
+		return err
+	}
+	const interval = 100 * time.Millisecond
+	chunkSize := max(1, bandwidth/int(time.Second/interval))
+	for len(data) > 0 {
+		chunk := data[:min(chunkSize, len(data))]
+		data = data[len(chunk):]
+		if _, err := writer.ResponseWriter.Write(chunk); err != nil {
+			return err
+		}
+		http.NewResponseController(writer.ResponseWriter).Flush()
+		if len(data) > 0 {
+			if !sleep(time.Second*time.Duration(len(chunk))/time.Duration(bandwidth), writer.request.Context().Done()) {
+				return writer.request.Context().Err()
+			}
+		}
+	}
+	return nil
+}
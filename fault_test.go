@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFaultBody is the body of all responses with a fault.
+var testFaultBody = strings.Repeat("0123456789", 20)
+
+// newFaultTestServer serves /fault with testFaultBody and the given response
+// fault over a real connection, as faults may abort it.
+func newFaultTestServer(t *testing.T, fault string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /fault
+    method: GET
+    actions:
+      - type: response
+        params:
+          body: '` + testFaultBody + `'
+          fault: ` + fault + `
+`}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFaultError(t *testing.T) {
+	server := newFaultTestServer(t, "{error: {status: 503, body: unavailable}}")
+	response, err := http.Get(server.URL + "/fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusServiceUnavailable || string(body) != "unavailable" {
+		t.Errorf("got %d %q, want 503 unavailable", response.StatusCode, body)
+	}
+}
+
+func TestFaultProbability(t *testing.T) {
+	for _, test := range []struct {
+		probability string
+		status      int
+	}{{"0", http.StatusOK}, {"1", http.StatusInternalServerError}} {
+		server := newFaultTestServer(t, "{probability: "+test.probability+", error: {}}")
+		for i := 0; i < 10; i++ {
+			response, err := http.Get(server.URL + "/fault")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != test.status {
+				t.Fatalf("probability %s: got %d, want %d", test.probability, response.StatusCode, test.status)
+			}
+		}
+	}
+}
+
+func TestFaultDrop(t *testing.T) {
+	server := newFaultTestServer(t, "{drop: true}")
+	if response, err := http.Get(server.URL + "/fault"); err == nil {
+		response.Body.Close()
+		t.Errorf("got %d, want a dropped connection", response.StatusCode)
+	}
+}
+
+func TestFaultTruncate(t *testing.T) {
+	server := newFaultTestServer(t, "{truncate: 0.5}")
+	response, err := http.Get(server.URL + "/fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	// the full length is announced, but only half of the body is sent
+	if response.ContentLength != int64(len(testFaultBody)) {
+		t.Errorf("got Content-Length %d, want %d", response.ContentLength, len(testFaultBody))
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v, want an unexpected EOF", err)
+	}
+	if string(body) != testFaultBody[:len(testFaultBody)/2] {
+		t.Errorf("got body %q, want the first half", body)
+	}
+}
+
+func TestFaultMalformed(t *testing.T) {
+	server := newFaultTestServer(t, "{malformed: true}")
+	response, err := http.Get(server.URL + "/fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != len(testFaultBody) || bytes.Equal(body, []byte(testFaultBody)) {
+		t.Errorf("got body %q, want random bytes of the same length", body)
+	}
+}
+
+func TestFaultBandwidth(t *testing.T) {
+	// 200 bytes at 500 bytes per second are sent in 50 byte chunks, one
+	// every 100ms
+	server := newFaultTestServer(t, "{bandwidth: 500}")
+	start := time.Now()
+	response, err := http.Get(server.URL + "/fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil || string(body) != testFaultBody {
+		t.Fatalf("got body %q, error %v", body, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("body sent in %s, want about 300ms", elapsed)
+	}
+}
+
+func TestServerFault(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+server:
+  fault:
+    error: {status: 502, body: bad gateway}
+endpoints:
+  - url: /a
+    method: GET
+    actions:
+      - type: response
+        params: {body: a}
+`})
+	if status, body := serveTest(server, http.MethodGet, "/a", "", nil); status != http.StatusBadGateway || body != "bad gateway" {
+		t.Errorf("got %d %q, want 502 bad gateway", status, body)
+	}
+	_, body := serveTest(server, http.MethodGet, "/__admin/requests", "", nil)
+	if !strings.Contains(body, "fault: error 502") {
+		t.Errorf("fault missing in the journal: %s", body)
+	}
+}
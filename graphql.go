@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// GraphqlStruct turns an endpoint into a GraphQL endpoint serving queries and
+// mutations against a schema. The actions of the endpoint run before the
+// operation, so they may still reject it with a response. Every field is
+// resolved by its resolver, else from the value of its parent object, else
+// by a generated fake value.
+type GraphqlStruct struct {
+	// SDL schema file
+	Schema string `json:"schema,omitempty" yaml:",omitempty"`
+	// inline SDL schema, instead of a file
+	Sdl string `json:"sdl,omitempty" yaml:",omitempty"`
+	// Resolvers by `<Type>.<field>`
+	Resolvers map[string]GraphqlResolverStruct `json:"resolvers,omitempty" yaml:",omitempty"`
+	// Generate values of unresolved fields instead of null [default=true]
+	Fake *bool `json:"fake,omitempty" yaml:",omitempty"`
+	// Length of generated lists [default=2]
+	ListLength int `json:"listLength,omitempty" yaml:"listLength,omitempty"`
+}
+
+// GraphqlResolverStruct resolves a field by the first of error, cache, path
+// and value which is given. The field arguments are available as `__args__`
+// and the value of the parent object as `__parent__`.
+type GraphqlResolverStruct struct {
+	// Actions run before the field is resolved, e.g. to update the cache
+	Actions []ActionStruct `json:"actions,omitempty" yaml:",omitempty"`
+	// Fail the field with this message (templated)
+	Error string `json:"error,omitempty" yaml:",omitempty"`
+	// Key of the global cache in the endpoint namespace (templated)
+	Cache string `json:"cache,omitempty" yaml:",omitempty"`
+	// Context path, e.g. `__parent__.friends`
+	Path string `json:"path,omitempty" yaml:",omitempty"`
+	// Fixture whose strings are templated
+	Value interface{} `json:"value,omitempty" yaml:",omitempty"`
+}
+
+type graphqlResolver struct {
+	config   GraphqlResolverStruct
+	handlers []ActionHandler
+	hasValue bool
+}
+
+// graphqlEndpoint executes the operations of one endpoint.
+type graphqlEndpoint struct {
+	endpoint      EndpointStruct
+	schema        *ast.Schema
+	resolvers     map[string]*graphqlResolver
+	fake          bool
+	listLength    int
+	introspection *graphqlIntrospection
+}
+
+func newGraphqlEndpoint(endpoint EndpointStruct) *graphqlEndpoint {
+	config := endpoint.Graphql
+	source := &ast.Source{Name: "sdl", Input: config.Sdl}
+	if config.Schema != "" {
+		if config.Sdl != "" {
+			log.Panicf("GraphQL endpoint %s: specify either a schema file or sdl", endpoint.Url)
+		}
+		bytes, err := os.ReadFile(config.Schema)
+		if err != nil {
+			log.Panicf("GraphQL endpoint %s: %v", endpoint.Url, err)
+		}
+		source = &ast.Source{Name: config.Schema, Input: string(bytes)}
+	}
+	schema, err := gqlparser.LoadSchema(source)
+	if err != nil {
+		log.Panicf("GraphQL endpoint %s: invalid schema: %v", endpoint.Url, err)
+	}
+
+	graphql := &graphqlEndpoint{
+		endpoint:      endpoint,
+		schema:        schema,
+		resolvers:     make(map[string]*graphqlResolver),
+		fake:          config.Fake == nil || *config.Fake,
+		listLength:    config.ListLength,
+		introspection: newGraphqlIntrospection(schema),
+	}
+	if graphql.listLength <= 0 {
+		graphql.listLength = 2
+	}
+	for name, resolverConfig := range config.Resolvers {
+		typeName, fieldName, _ := strings.Cut(name, ".")
+		if definition := schema.Types[typeName]; definition == nil || definition.Kind != ast.Object ||
+			definition.Fields.ForName(fieldName) == nil {
+			log.Panicf("GraphQL endpoint %s: resolver '%s' does not name a field of an object type", endpoint.Url, name)
+		}
+		graphql.resolvers[name] = &graphqlResolver{
+			config:   resolverConfig,
+			handlers: createActionHandlers(endpoint, resolverConfig.Actions),
+			hasValue: resolverConfig.Value != nil,
+		}
+	}
+	log.Printf("| {graphql=%d types, %d resolvers, fake: %v}", len(schema.Types), len(graphql.resolvers), graphql.fake)
+	return graphql
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphqlError is an error of the response, located in the query and, for
+// field errors, in the data.
+type GraphqlError struct {
+	Message   string            `json:"message"`
+	Locations []GraphqlLocation `json:"locations,omitempty"`
+	Path      []interface{}     `json:"path,omitempty"`
+}
+
+type GraphqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// serve executes the operation of the request and responds with its data
+// and errors.
+func (graphql *graphqlEndpoint) serve(
+	requestId string,
+	response http.ResponseWriter,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) {
+	gqlRequest, status, err := readGraphqlRequest(request)
+	if err != nil {
+		writeGraphqlResponse(response, status, map[string]interface{}{
+			"errors": []GraphqlError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	document, queryErrors := gqlparser.LoadQuery(graphql.schema, gqlRequest.Query)
+	if len(queryErrors) > 0 {
+		errs := make([]GraphqlError, 0, len(queryErrors))
+		for _, queryError := range queryErrors {
+			gqlError := GraphqlError{Message: queryError.Message}
+			for _, location := range queryError.Locations {
+				gqlError.Locations = append(gqlError.Locations, GraphqlLocation{location.Line, location.Column})
+			}
+			errs = append(errs, gqlError)
+		}
+		writeGraphqlResponse(response, http.StatusOK, map[string]interface{}{"errors": errs})
+		return
+	}
+	operation := document.Operations.ForName(gqlRequest.OperationName)
+	if operation == nil {
+		writeGraphqlResponse(response, http.StatusOK, map[string]interface{}{
+			"errors": []GraphqlError{{Message: fmt.Sprintf("operation '%s' not found", gqlRequest.OperationName)}},
+		})
+		return
+	}
+	root := graphql.schema.Query
+	switch operation.Operation {
+	case ast.Mutation:
+		if request.Method == http.MethodGet {
+			writeGraphqlResponse(response, http.StatusMethodNotAllowed, map[string]interface{}{
+				"errors": []GraphqlError{{Message: "mutations require POST"}},
+			})
+			return
+		}
+		root = graphql.schema.Mutation
+	case ast.Subscription:
+		writeGraphqlResponse(response, http.StatusOK, map[string]interface{}{
+			"errors": []GraphqlError{{Message: "subscriptions are not supported"}},
+		})
+		return
+	}
+	variables, err := validator.VariableValues(graphql.schema, operation, gqlRequest.Variables)
+	if err != nil {
+		writeGraphqlResponse(response, http.StatusOK, map[string]interface{}{
+			"errors": []GraphqlError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	execution := &graphqlExecution{
+		graphqlEndpoint: graphql,
+		document:        document,
+		variables:       variables,
+		requestId:       requestId,
+		request:         request,
+		params:          params,
+		context:         context,
+	}
+	data, _ := execution.executeFields(root, []ast.SelectionSet{operation.SelectionSet}, nil, nil, graphql.fake)
+	result := map[string]interface{}{"data": data}
+	if len(execution.errors) > 0 {
+		result["errors"] = execution.errors
+	}
+	log.Printf("[%s] graphql %s %s: %d errors", requestId, operation.Operation, operation.Name, len(execution.errors))
+	writeGraphqlResponse(response, http.StatusOK, result)
+}
+
+// readGraphqlRequest reads the operation from the query params of a GET
+// request or from the JSON (or application/graphql) body of a POST request.
+func readGraphqlRequest(request *http.Request) (*graphqlRequest, int, error) {
+	gqlRequest := &graphqlRequest{}
+	switch request.Method {
+	case http.MethodGet:
+		query := request.URL.Query()
+		gqlRequest.Query = query.Get("query")
+		gqlRequest.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &gqlRequest.Variables); err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("invalid variables: %v", err)
+			}
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); mediaType == "application/graphql" {
+			gqlRequest.Query = string(body)
+		} else {
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if err := decoder.Decode(gqlRequest); err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err)
+			}
+		}
+	default:
+		return nil, http.StatusMethodNotAllowed, fmt.Errorf("method %s not supported, use GET or POST", request.Method)
+	}
+	if gqlRequest.Query == "" {
+		return nil, http.StatusBadRequest, errors.New("missing query")
+	}
+	return gqlRequest, http.StatusOK, nil
+}
+
+func writeGraphqlResponse(response http.ResponseWriter, status int, result map[string]interface{}) {
+	body, _ := json.Marshal(result)
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	response.Write(body)
+}
+
+// graphqlExecution is the execution of one operation.
+type graphqlExecution struct {
+	*graphqlEndpoint
+	document  *ast.QueryDocument
+	variables map[string]interface{}
+	errors    []GraphqlError
+	requestId string
+	request   *http.Request
+	params    httprouter.Params
+	context   map[string]interface{}
+}
+
+func (execution *graphqlExecution) addError(field *ast.Field, path []interface{}, message string) {
+	gqlError := GraphqlError{Message: message, Path: append([]interface{}{}, path...)}
+	if field.Position != nil {
+		gqlError.Locations = []GraphqlLocation{{field.Position.Line, field.Position.Column}}
+	}
+	execution.errors = append(execution.errors, gqlError)
+}
+
+// executeFields resolves the fields selected on an object of objectType
+// whose value is parent. If a non-null field is null, the object is null and
+// propagate is true.
+func (execution *graphqlExecution) executeFields(
+	objectType *ast.Definition,
+	selectionSets []ast.SelectionSet,
+	parent map[string]interface{},
+	path []interface{},
+	fake bool,
+) (result *graphqlObject, propagate bool) {
+	keys, fields := []string{}, map[string][]*ast.Field{}
+	for _, selectionSet := range selectionSets {
+		execution.collectFields(objectType, selectionSet, &keys, fields, map[string]bool{})
+	}
+	result = &graphqlObject{values: make(map[string]interface{}, len(keys))}
+	for _, key := range keys {
+		field := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if field.Name == "__typename" {
+			result.set(key, objectType.Name)
+			continue
+		}
+
+		var (
+			value     interface{}
+			resolved  bool
+			err       error
+			fieldFake = fake
+		)
+		switch {
+		case objectType == execution.schema.Query && field.Name == "__schema":
+			value, resolved, fieldFake = execution.introspection.schema, true, false
+		case objectType == execution.schema.Query && field.Name == "__type":
+			name, _ := field.ArgumentMap(execution.variables)["name"].(string)
+			if introspected, exists := execution.introspection.types[name]; exists {
+				value = introspected
+			}
+			resolved, fieldFake = true, false
+		default:
+			value, resolved, err = execution.resolveField(objectType, field, parent)
+		}
+		if err != nil {
+			execution.addError(field, fieldPath, err.Error())
+			if field.Definition.Type.NonNull {
+				return nil, true
+			}
+			result.set(key, nil)
+			continue
+		}
+
+		selections := make([]ast.SelectionSet, 0, len(fields[key]))
+		for _, field := range fields[key] {
+			selections = append(selections, field.SelectionSet)
+		}
+		completed, propagate := execution.completeValue(field.Definition.Type, field, selections, value, fieldPath, fieldFake && !resolved)
+		if propagate {
+			return nil, true
+		}
+		result.set(key, completed)
+	}
+	return result, false
+}
+
+// collectFields groups the fields of selectionSet applying to objectType by
+// their response keys, in the order of the query.
+func (execution *graphqlExecution) collectFields(
+	objectType *ast.Definition,
+	selectionSet ast.SelectionSet,
+	keys *[]string,
+	fields map[string][]*ast.Field,
+	visited map[string]bool,
+) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if !execution.included(selection.Directives) {
+				continue
+			}
+			key := selection.Alias
+			if key == "" {
+				key = selection.Name
+			}
+			if _, exists := fields[key]; !exists {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], selection)
+		case *ast.InlineFragment:
+			if execution.included(selection.Directives) && execution.applies(objectType, selection.TypeCondition) {
+				execution.collectFields(objectType, selection.SelectionSet, keys, fields, visited)
+			}
+		case *ast.FragmentSpread:
+			if !execution.included(selection.Directives) || visited[selection.Name] {
+				continue
+			}
+			visited[selection.Name] = true
+			fragment := selection.Definition
+			if fragment == nil {
+				fragment = execution.document.Fragments.ForName(selection.Name)
+			}
+			if fragment != nil && execution.applies(objectType, fragment.TypeCondition) {
+				execution.collectFields(objectType, fragment.SelectionSet, keys, fields, visited)
+			}
+		}
+	}
+}
+
+// included evaluates the @skip and @include directives of a selection.
+func (execution *graphqlExecution) included(directives ast.DirectiveList) bool {
+	if skip := directives.ForName("skip"); skip != nil {
+		if value, _ := skip.ArgumentMap(execution.variables)["if"].(bool); value {
+			return false
+		}
+	}
+	if include := directives.ForName("include"); include != nil {
+		if value, _ := include.ArgumentMap(execution.variables)["if"].(bool); !value {
+			return false
+		}
+	}
+	return true
+}
+
+// applies reports whether a fragment with typeCondition applies to objects of
+// objectType.
+func (execution *graphqlExecution) applies(objectType *ast.Definition, typeCondition string) bool {
+	if typeCondition == "" || typeCondition == objectType.Name {
+		return true
+	}
+	for _, implemented := range execution.schema.GetImplements(objectType) {
+		if implemented.Name == typeCondition {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveField runs the resolver of a field, if any, or looks the field up in
+// the value of its parent object. It reports whether the field was resolved,
+// unresolved fields are faked.
+func (execution *graphqlExecution) resolveField(
+	objectType *ast.Definition,
+	field *ast.Field,
+	parent map[string]interface{},
+) (value interface{}, resolved bool, err error) {
+	resolver, exists := execution.resolvers[objectType.Name+"."+field.Name]
+	if !exists {
+		value, resolved = parent[field.Name]
+		return value, resolved, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if failure, ok := r.(*ActionError); ok {
+				err = errors.New(failure.Message)
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	context := copyContext(execution.context)
+	context["__args__"] = field.ArgumentMap(execution.variables)
+	context["__parent__"] = parent
+	global := newGlobalSnapshot(context["__namespace__"].(string))
+	for _, handler := range resolver.handlers {
+		context["__global__"] = global.get()
+		handler(execution.requestId, discardWriter{header: make(http.Header)}, execution.request, execution.params, context)
+	}
+
+	config := resolver.config
+	switch {
+	case config.Error != "":
+		return nil, true, errors.New(fromTemplate(config.Error, context))
+	case config.Cache != "":
+		key := namespacedKey(contextNamespace("", context), fromTemplate(config.Cache, context))
+		value = globalContext.Get(key, nil)
+		return value, value != nil, nil
+	case config.Path != "":
+		value, err := (&PathAccessor{context}).Must(config.Path)
+		return value, err == nil, nil
+	case resolver.hasValue:
+		return renderTemplates(config.Value, context), true, nil
+	}
+	return nil, false, nil
+}
+
+// completeValue converts value to typ, resolving the selected fields of
+// objects. If a non-null value is null, the error is reported and propagate
+// is true.
+func (execution *graphqlExecution) completeValue(
+	typ *ast.Type,
+	field *ast.Field,
+	selections []ast.SelectionSet,
+	value interface{},
+	path []interface{},
+	fake bool,
+) (result interface{}, propagate bool) {
+	if typ.NonNull {
+		nullable := *typ
+		nullable.NonNull = false
+		result, reported := execution.completeNullable(&nullable, field, selections, value, path, fake)
+		if result == nil {
+			if !reported {
+				execution.addError(field, path, "Cannot return null for non-nullable field")
+			}
+			return nil, true
+		}
+		return result, false
+	}
+	result, _ = execution.completeNullable(typ, field, selections, value, path, fake)
+	return result, false
+}
+
+// completeNullable completes the value of a nullable type. A null result is
+// reported if it is caused by an error which was already added.
+func (execution *graphqlExecution) completeNullable(
+	typ *ast.Type,
+	field *ast.Field,
+	selections []ast.SelectionSet,
+	value interface{},
+	path []interface{},
+	fake bool,
+) (result interface{}, reported bool) {
+	if value == nil && !fake {
+		return nil, false
+	}
+	if typ.Elem != nil {
+		var items []interface{}
+		switch value := value.(type) {
+		case nil:
+			items = make([]interface{}, execution.listLength)
+		case []interface{}:
+			items = value
+		default:
+			items = []interface{}{value}
+		}
+		results := make([]interface{}, len(items))
+		for i, item := range items {
+			itemPath := append(append([]interface{}{}, path...), i)
+			completed, propagate := execution.completeValue(typ.Elem, field, selections, item, itemPath, fake && item == nil)
+			if propagate {
+				return nil, true
+			}
+			results[i] = completed
+		}
+		return results, false
+	}
+
+	definition := execution.schema.Types[typ.NamedType]
+	switch definition.Kind {
+	case ast.Scalar:
+		if value == nil {
+			value = fakeScalar(definition.Name, field.Name, path)
+		}
+		coerced, err := coerceScalar(definition.Name, value)
+		if err != nil {
+			execution.addError(field, path, err.Error())
+			return nil, true
+		}
+		return coerced, false
+	case ast.Enum:
+		if value == nil {
+			return definition.EnumValues[0].Name, false
+		}
+		name := jsonValueString(value)
+		if definition.EnumValues.ForName(name) == nil {
+			execution.addError(field, path, fmt.Sprintf("Enum %s has no value %s", definition.Name, name))
+			return nil, true
+		}
+		return name, false
+	}
+
+	parent, isObject := value.(map[string]interface{})
+	if value != nil && !isObject {
+		execution.addError(field, path, fmt.Sprintf("Cannot represent %v as %s", value, definition.Name))
+		return nil, true
+	}
+	objectType := execution.concreteType(definition, parent)
+	if objectType == nil {
+		execution.addError(field, path, fmt.Sprintf("Cannot determine the type of %s", definition.Name))
+		return nil, true
+	}
+	object, propagate := execution.executeFields(objectType, selections, parent, path, fake)
+	if propagate {
+		return nil, true
+	}
+	return object, false
+}
+
+// concreteType returns the object type of value for an interface or union,
+// given by its `__typename` or else the first possible type.
+func (execution *graphqlExecution) concreteType(definition *ast.Definition, value map[string]interface{}) *ast.Definition {
+	if definition.Kind == ast.Object {
+		return definition
+	}
+	possibleTypes := execution.schema.GetPossibleTypes(definition)
+	if typeName, ok := value["__typename"].(string); ok {
+		for _, possibleType := range possibleTypes {
+			if possibleType.Name == typeName {
+				return possibleType
+			}
+		}
+		return nil
+	}
+	if len(possibleTypes) == 0 {
+		return nil
+	}
+	return possibleTypes[0]
+}
+
+// coerceScalar converts value to the built-in scalar typeName. Custom scalars
+// are passed as is.
+func coerceScalar(typeName string, value interface{}) (interface{}, error) {
+	text := jsonValueString(value)
+	switch typeName {
+	case "Int":
+		if number, err := strconv.ParseFloat(text, 64); err == nil && number == math.Trunc(number) &&
+			number >= math.MinInt32 && number <= math.MaxInt32 {
+			return int(number), nil
+		}
+	case "Float":
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			return number, nil
+		}
+	case "Boolean":
+		if boolean, err := strconv.ParseBool(text); err == nil {
+			return boolean, nil
+		}
+	case "String", "ID":
+		return text, nil
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("Cannot represent %s as %s", text, typeName)
+}
+
+// fakeScalar generates a value of typeName, which is stable for the path of
+// the field and shaped by its name.
+func fakeScalar(typeName string, fieldName string, path []interface{}) interface{} {
+	hash := fnv.New32a()
+	fmt.Fprint(hash, path...)
+	seed := int(hash.Sum32() % 100000)
+	switch typeName {
+	case "Int":
+		return seed % 1000
+	case "Float":
+		return float64(seed) / 100
+	case "Boolean":
+		return seed%2 == 0
+	case "ID":
+		return strconv.Itoa(seed)
+	}
+	lower := strings.ToLower(fieldName)
+	switch {
+	case strings.Contains(lower, "email"):
+		return fmt.Sprintf("user%d@example.com", seed)
+	case strings.Contains(lower, "url") || strings.Contains(lower, "link"):
+		return fmt.Sprintf("https://example.com/%d", seed)
+	case strings.HasSuffix(fieldName, "At") || strings.Contains(lower, "date") || strings.Contains(lower, "time"):
+		return time.Unix(1700000000+int64(seed)*60, 0).UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s %d", fieldName, seed)
+}
+
+// graphqlObject is a result object, which keeps its fields in the order of
+// the query.
+type graphqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (object *graphqlObject) set(key string, value interface{}) {
+	if _, exists := object.values[key]; !exists {
+		object.keys = append(object.keys, key)
+	}
+	object.values[key] = value
+}
+
+func (object *graphqlObject) MarshalJSON() ([]byte, error) {
+	if object == nil {
+		return []byte("null"), nil
+	}
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for i, key := range object.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		keyBytes, _ := json.Marshal(key)
+		valueBytes, err := json.Marshal(object.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(keyBytes)
+		buffer.WriteByte(':')
+		buffer.Write(valueBytes)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// discardWriter is the response of actions whose results are only passed on
+// in the context.
+type discardWriter struct {
+	header http.Header
+}
+
+func (writer discardWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer discardWriter) WriteHeader(status int) {}
+
+func (writer discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// graphqlIntrospection holds the values of the introspection fields
+// `__schema` and `__type`, shaped like the introspection types so they are
+// completed like any other value.
+type graphqlIntrospection struct {
+	schema map[string]interface{}
+	types  map[string]map[string]interface{}
+}
+
+func newGraphqlIntrospection(schema *ast.Schema) *graphqlIntrospection {
+	introspection := &graphqlIntrospection{types: make(map[string]map[string]interface{}, len(schema.Types))}
+	names := make([]string, 0, len(schema.Types))
+	for name := range schema.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// types reference each other, so all are created before being filled
+	for _, name := range names {
+		introspection.types[name] = map[string]interface{}{}
+	}
+	types := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		introspection.fillType(schema, schema.Types[name])
+		types = append(types, introspection.types[name])
+	}
+
+	directives := make([]interface{}, 0, len(schema.Directives))
+	directiveNames := make([]string, 0, len(schema.Directives))
+	for name := range schema.Directives {
+		directiveNames = append(directiveNames, name)
+	}
+	sort.Strings(directiveNames)
+	for _, name := range directiveNames {
+		directive := schema.Directives[name]
+		locations := make([]interface{}, 0, len(directive.Locations))
+		for _, location := range directive.Locations {
+			locations = append(locations, string(location))
+		}
+		directives = append(directives, map[string]interface{}{
+			"name":         directive.Name,
+			"description":  optionalString(directive.Description),
+			"locations":    locations,
+			"args":         introspection.arguments(directive.Arguments),
+			"isRepeatable": directive.IsRepeatable,
+		})
+	}
+
+	introspection.schema = map[string]interface{}{
+		"description":      optionalString(schema.Description),
+		"types":            types,
+		"queryType":        introspection.namedType(schema.Query),
+		"mutationType":     introspection.namedType(schema.Mutation),
+		"subscriptionType": introspection.namedType(schema.Subscription),
+		"directives":       directives,
+	}
+	return introspection
+}
+
+func (introspection *graphqlIntrospection) fillType(schema *ast.Schema, definition *ast.Definition) {
+	introspected := introspection.types[definition.Name]
+	introspected["kind"] = string(definition.Kind)
+	introspected["name"] = definition.Name
+	introspected["description"] = optionalString(definition.Description)
+	introspected["specifiedByURL"] = nil
+	if specifiedBy := definition.Directives.ForName("specifiedBy"); specifiedBy != nil {
+		if url := specifiedBy.Arguments.ForName("url"); url != nil {
+			introspected["specifiedByURL"] = url.Value.Raw
+		}
+	}
+
+	switch definition.Kind {
+	case ast.Object, ast.Interface:
+		fields := make([]interface{}, 0, len(definition.Fields))
+		for _, field := range definition.Fields {
+			// the introspection fields of the query type are implicit
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			deprecated, reason := deprecation(field.Directives)
+			fields = append(fields, map[string]interface{}{
+				"name":              field.Name,
+				"description":       optionalString(field.Description),
+				"args":              introspection.arguments(field.Arguments),
+				"type":              introspection.typeRef(field.Type),
+				"isDeprecated":      deprecated,
+				"deprecationReason": reason,
+			})
+		}
+		introspected["fields"] = fields
+		interfaces := make([]interface{}, 0, len(definition.Interfaces))
+		for _, name := range definition.Interfaces {
+			interfaces = append(interfaces, introspection.types[name])
+		}
+		introspected["interfaces"] = interfaces
+	case ast.InputObject:
+		fields := make(ast.ArgumentDefinitionList, 0, len(definition.Fields))
+		for _, field := range definition.Fields {
+			fields = append(fields, &ast.ArgumentDefinition{
+				Name:         field.Name,
+				Description:  field.Description,
+				DefaultValue: field.DefaultValue,
+				Type:         field.Type,
+				Directives:   field.Directives,
+			})
+		}
+		introspected["inputFields"] = introspection.arguments(fields)
+	case ast.Enum:
+		values := make([]interface{}, 0, len(definition.EnumValues))
+		for _, value := range definition.EnumValues {
+			deprecated, reason := deprecation(value.Directives)
+			values = append(values, map[string]interface{}{
+				"name":              value.Name,
+				"description":       optionalString(value.Description),
+				"isDeprecated":      deprecated,
+				"deprecationReason": reason,
+			})
+		}
+		introspected["enumValues"] = values
+	}
+	if definition.IsAbstractType() {
+		possibleTypes := make([]interface{}, 0)
+		for _, possibleType := range schema.GetPossibleTypes(definition) {
+			possibleTypes = append(possibleTypes, introspection.types[possibleType.Name])
+		}
+		introspected["possibleTypes"] = possibleTypes
+	}
+}
+
+func (introspection *graphqlIntrospection) arguments(arguments ast.ArgumentDefinitionList) []interface{} {
+	introspected := make([]interface{}, 0, len(arguments))
+	for _, argument := range arguments {
+		var defaultValue interface{}
+		if argument.DefaultValue != nil {
+			defaultValue = argument.DefaultValue.String()
+		}
+		deprecated, reason := deprecation(argument.Directives)
+		introspected = append(introspected, map[string]interface{}{
+			"name":              argument.Name,
+			"description":       optionalString(argument.Description),
+			"type":              introspection.typeRef(argument.Type),
+			"defaultValue":      defaultValue,
+			"isDeprecated":      deprecated,
+			"deprecationReason": reason,
+		})
+	}
+	return introspected
+}
+
+// typeRef returns the introspected type of a reference, wrapping named types
+// in lists and non-null types.
+func (introspection *graphqlIntrospection) typeRef(typ *ast.Type) map[string]interface{} {
+	if typ.NonNull {
+		nullable := *typ
+		nullable.NonNull = false
+		return map[string]interface{}{"kind": "NON_NULL", "ofType": introspection.typeRef(&nullable)}
+	}
+	if typ.Elem != nil {
+		return map[string]interface{}{"kind": "LIST", "ofType": introspection.typeRef(typ.Elem)}
+	}
+	return introspection.types[typ.NamedType]
+}
+
+func (introspection *graphqlIntrospection) namedType(definition *ast.Definition) interface{} {
+	if definition == nil {
+		return nil
+	}
+	return introspection.types[definition.Name]
+}
+
+func deprecation(directives ast.DirectiveList) (bool, interface{}) {
+	deprecated := directives.ForName("deprecated")
+	if deprecated == nil {
+		return false, nil
+	}
+	if reason := deprecated.Arguments.ForName("reason"); reason != nil {
+		return true, reason.Value.Raw
+	}
+	return true, "No longer supported"
+}
+
+func optionalString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const testGraphqlConfig = `
+endpoints:
+  - url: /graphql
+    method: POST
+    graphql:
+      fake: false
+      sdl: |
+        type Query {
+          user(id: ID!): User
+          users: [User!]!
+          broken: User
+          search: [SearchResult]
+          status: Status
+        }
+        type User {
+          id: ID!
+          name: String
+          email: String!
+          old: String @deprecated(reason: "use name")
+        }
+        type Post { title: String }
+        union SearchResult = User | Post
+        enum Status { ACTIVE, INACTIVE }
+      resolvers:
+        Query.user:
+          value: {id: '{{index .__args__ "id"}}', name: Ada, email: ada@example.com}
+        Query.broken:
+          value: {id: "1", name: Broken}
+        Query.search:
+          value:
+            - {__typename: Post, title: Hello}
+            - {__typename: User, id: "2", name: Bob, email: bob@example.com}
+        Query.status:
+          error: 'status of {{index .__headers__ "X-Tenant"}} unavailable'
+`
+
+func TestGraphqlExecution(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testGraphqlConfig})
+
+	for _, test := range []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			name:  "aliases",
+			query: `{ a: user(id: "1") { name } b: user(id: "2") { id } }`,
+			want:  `{"data":{"a":{"name":"Ada"},"b":{"id":"2"}}}`,
+		},
+		{
+			name: "fragments",
+			query: `query { user(id: "1") { ...names } search { __typename ... on Post { title } ... on User { name } } }
+fragment names on User { id name }`,
+			want: `{"data":{"user":{"id":"1","name":"Ada"},"search":[{"__typename":"Post","title":"Hello"},{"__typename":"User","name":"Bob"}]}}`,
+		},
+		{
+			name:      "skip and include",
+			query:     `query($skip: Boolean!, $include: Boolean!) { user(id: "1") { id @skip(if: $skip) name @include(if: $include) email } }`,
+			variables: map[string]interface{}{"skip": true, "include": false},
+			want:      `{"data":{"user":{"email":"ada@example.com"}}}`,
+		},
+		{
+			name:  "null propagates to the nullable parent",
+			query: `{ broken { id email } }`,
+			want:  `{"data":{"broken":null},"errors":[{"message":"Cannot return null for non-nullable field","locations":[{"line":1,"column":15}],"path":["broken","email"]}]}`,
+		},
+		{
+			name:  "null propagates to the data",
+			query: `{ user(id: "1") { id } users { id } }`,
+			want:  `{"data":null,"errors":[{"message":"Cannot return null for non-nullable field","locations":[{"line":1,"column":24}],"path":["users"]}]}`,
+		},
+		{
+			name:  "resolver errors",
+			query: `{ status }`,
+			want:  `{"data":{"status":null},"errors":[{"message":"status of acme unavailable","locations":[{"line":1,"column":3}],"path":["status"]}]}`,
+		},
+		{
+			name:  "missing variable",
+			query: `query($id: ID!) { user(id: $id) { id } }`,
+			want:  `{"errors":[{"message":"input: variable.id must be defined"}]}`,
+		},
+		{
+			name:      "invalid variable",
+			query:     `query($skip: Boolean!) { user(id: "1") { id @skip(if: $skip) } }`,
+			variables: map[string]interface{}{"skip": "yes"},
+			want:      `{"errors":[{"message":"input: variable.skip cannot use string as Boolean"}]}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ user(id: "1") { age } }`,
+			want:  `{"errors":[{"message":"Cannot query field \"age\" on type \"User\". Did you mean \"name\"?","locations":[{"line":1,"column":19}]}]}`,
+		},
+		{
+			name:  "introspection of a type",
+			query: `{ __type(name: "User") { name kind fields { name isDeprecated deprecationReason type { kind ofType { name } } } } }`,
+			want: `{"data":{"__type":{"name":"User","kind":"OBJECT","fields":[` +
+				`{"name":"id","isDeprecated":false,"deprecationReason":null,"type":{"kind":"NON_NULL","ofType":{"name":"ID"}}},` +
+				`{"name":"name","isDeprecated":false,"deprecationReason":null,"type":{"kind":"SCALAR","ofType":null}},` +
+				`{"name":"email","isDeprecated":false,"deprecationReason":null,"type":{"kind":"NON_NULL","ofType":{"name":"String"}}},` +
+				`{"name":"old","isDeprecated":true,"deprecationReason":"use name","type":{"kind":"SCALAR","ofType":null}}]}}}`,
+		},
+		{
+			name:  "introspection of the schema",
+			query: `{ __schema { queryType { name } mutationType { name } } __type(name: "Missing") { name } }`,
+			want:  `{"data":{"__schema":{"queryType":{"name":"Query"},"mutationType":null},"__type":null}}`,
+		},
+		{
+			name:  "introspection of unions and enums",
+			query: `{ union: __type(name: "SearchResult") { possibleTypes { name } } enum: __type(name: "Status") { enumValues { name } } }`,
+			want:  `{"data":{"union":{"possibleTypes":[{"name":"User"},{"name":"Post"}]},"enum":{"enumValues":[{"name":"ACTIVE"},{"name":"INACTIVE"}]}}}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"query": test.query, "variables": test.variables})
+			status, response := serveTest(server, http.MethodPost, "/graphql", string(body), map[string]string{
+				"Content-Type": "application/json",
+				"X-Tenant":     "acme",
+			})
+			if status != http.StatusOK || response != test.want {
+				t.Errorf("got %d %s, want %s", status, response, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	v1reflectiongrpc "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	v1alphareflectiongrpc "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v3"
+)
+
+// GrpcConfig is a gRPC listener of a server, serving mocked methods of the
+// services loaded from proto definitions.
+type GrpcConfig struct {
+	// port of the listener, on the ip of the server
+	Port int
+	// .proto files (glob patterns) relative to the import paths
+	Protos []string
+	// directories of the protos and their imports, relative to the config
+	// file [default=its directory]
+	ImportPaths []string `yaml:"importPaths"`
+	// binary FileDescriptorSets, e.g. from `protoc --include_imports -o`
+	DescriptorSets []string `yaml:"descriptorSets"`
+	// serve the reflection service, e.g. for grpcurl
+	Reflection bool
+	Endpoints  []GrpcEndpointStruct
+}
+
+// GrpcEndpointStruct mocks a method. Its actions run like the actions of an
+// HTTP endpoint for a POST to `/<package>.<Service>/<Method>` with the request
+// message as JSON body and the metadata as headers.
+type GrpcEndpointStruct struct {
+	// `<package>.<Service>/<Method>`
+	Method    string         `json:"method"`
+	Name      string         `json:"name,omitempty" yaml:",omitempty"`
+	Match     *MatchStruct   `json:"match,omitempty" yaml:",omitempty"`
+	Namespace string         `json:"namespace,omitempty" yaml:",omitempty"`
+	Actions   []ActionStruct `json:"actions"`
+	OnError   []ActionStruct `json:"onError,omitempty" yaml:"onError,omitempty"`
+}
+
+// resolve validates the config and resolves its paths relative to
+// configFile. The protos are expanded to their names relative to the import
+// paths. All files read are returned, so that they can be watched.
+func (cfg *GrpcConfig) resolve(configFile string) ([]string, error) {
+	if cfg.Port <= 0 {
+		return nil, fmt.Errorf("grpc requires a port")
+	}
+	if len(cfg.Protos) == 0 && len(cfg.DescriptorSets) == 0 {
+		return nil, fmt.Errorf("grpc requires protos or descriptor sets")
+	}
+	if len(cfg.ImportPaths) == 0 {
+		cfg.ImportPaths = []string{"."}
+	}
+	for i, importPath := range cfg.ImportPaths {
+		cfg.ImportPaths[i] = resolveConfigPath(configFile, importPath)
+	}
+	files := []string{}
+	for i, descriptorSet := range cfg.DescriptorSets {
+		cfg.DescriptorSets[i] = resolveConfigPath(configFile, descriptorSet)
+		files = append(files, cfg.DescriptorSets[i])
+	}
+
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, pattern := range cfg.Protos {
+		found := false
+		for _, importPath := range cfg.ImportPaths {
+			matches, err := filepath.Glob(filepath.Join(importPath, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid proto pattern '%s': %w", pattern, err)
+			}
+			for _, match := range matches {
+				name, err := filepath.Rel(importPath, match)
+				if err != nil {
+					return nil, err
+				}
+				found = true
+				if name = filepath.ToSlash(name); !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+					files = append(files, match)
+				}
+			}
+		}
+		if !found && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("proto '%s' not found in the import paths", pattern)
+		}
+	}
+	cfg.Protos = names
+	return files, nil
+}
+
+// grpcServices are the descriptors and mocked methods of a gRPC listener,
+// replaced as a whole on reloads.
+type grpcServices struct {
+	files      *protoregistry.Files
+	types      *dynamicpb.Types
+	methods    map[string]protoreflect.MethodDescriptor
+	mocked     map[string]bool
+	router     http.Handler
+	reflection bool
+}
+
+// newGrpcServices loads the descriptors of cfg and creates the router of its
+// endpoints. Action failures are answered with the error status of server.
+func newGrpcServices(cfg *GrpcConfig, server *ServerConfig) (*grpcServices, error) {
+	files, err := loadDescriptors(cfg)
+	if err != nil {
+		return nil, err
+	}
+	services := &grpcServices{
+		files:      files,
+		types:      dynamicpb.NewTypes(files),
+		methods:    make(map[string]protoreflect.MethodDescriptor),
+		mocked:     make(map[string]bool),
+		reflection: cfg.Reflection,
+	}
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			service := file.Services().Get(i)
+			for j := 0; j < service.Methods().Len(); j++ {
+				method := service.Methods().Get(j)
+				services.methods[fmt.Sprintf("/%s/%s", service.FullName(), method.Name())] = method
+			}
+		}
+		return true
+	})
+
+	endpoints := make([]EndpointStruct, 0, len(cfg.Endpoints))
+	for _, grpcEndpoint := range cfg.Endpoints {
+		url := "/" + strings.TrimPrefix(grpcEndpoint.Method, "/")
+		if _, exists := services.methods[url]; !exists {
+			return nil, fmt.Errorf("grpc method '%s' not found in the loaded services", grpcEndpoint.Method)
+		}
+		services.mocked[url] = true
+		endpoints = append(endpoints, EndpointStruct{
+			Url:       url,
+			Method:    Methods{http.MethodPost},
+			Name:      grpcEndpoint.Name,
+			Match:     grpcEndpoint.Match,
+			Namespace: grpcEndpoint.Namespace,
+			Actions:   grpcEndpoint.Actions,
+			OnError:   grpcEndpoint.OnError,
+		})
+	}
+	// server faults are rejected next to a gRPC listener on config load
+	if services.router, err = buildRouter(endpoints, nil, &ServerConfig{ErrorStatus: server.ErrorStatus}); err != nil {
+		return nil, err
+	}
+	log.Printf("| gRPC: %d methods loaded, %d mocked", len(services.methods), len(services.mocked))
+	return services, nil
+}
+
+// loadDescriptors compiles the protos and reads the descriptor sets of cfg
+// into a single registry.
+func loadDescriptors(cfg *GrpcConfig) (*protoregistry.Files, error) {
+	files := &protoregistry.Files{}
+	if len(cfg.Protos) > 0 {
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: cfg.ImportPaths}),
+		}
+		compiled, err := compiler.Compile(context.Background(), cfg.Protos...)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range compiled {
+			if err := registerFile(files, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, path := range cfg.DescriptorSets {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			return nil, fmt.Errorf("invalid descriptor set '%s': %w", path, err)
+		}
+		setFiles, err := protodesc.NewFiles(set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid descriptor set '%s': %w", path, err)
+		}
+		setFiles.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+			err = registerFile(files, file)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// registerFile adds file and its imports to files, skipping files already
+// registered.
+func registerFile(files *protoregistry.Files, file protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(file.Path()); err == nil {
+		return nil
+	}
+	for i := 0; i < file.Imports().Len(); i++ {
+		if err := registerFile(files, file.Imports().Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(file)
+}
+
+// grpcListener serves the gRPC methods of a server with its current services.
+type grpcListener struct {
+	journal  *journal
+	services atomic.Pointer[grpcServices]
+	lock     sync.Mutex
+	server   *grpc.Server
+}
+
+// listen binds to addr and serves calls in the background until shutdown.
+func (listener *grpcListener) listen(addr string, serverTLS *serverTLS) error {
+	netListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	listener.serve(netListener, serverTLS)
+	return nil
+}
+
+// serve serves calls accepted by netListener in the background until
+// shutdown.
+func (listener *grpcListener) serve(netListener net.Listener, serverTLS *serverTLS) {
+	options := []grpc.ServerOption{grpc.UnknownServiceHandler(listener.handle)}
+	if serverTLS != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(serverTLS.config)))
+	}
+	server := grpc.NewServer(options...)
+	if listener.services.Load().reflection {
+		reflectionServer := reflection.NewServerV1(reflection.ServerOptions{
+			Services:           listener,
+			DescriptorResolver: listener,
+		})
+		v1reflectiongrpc.RegisterServerReflectionServer(server, reflectionServer)
+		v1alphareflectiongrpc.RegisterServerReflectionServer(server, reflection.NewServer(reflection.ServerOptions{
+			Services:           listener,
+			DescriptorResolver: listener,
+		}))
+	}
+	listener.lock.Lock()
+	listener.server = server
+	listener.lock.Unlock()
+	go func() {
+		if err := server.Serve(netListener); err != nil {
+			log.Printf("gRPC listener %s: %v", netListener.Addr(), err)
+		}
+	}()
+}
+
+// shutdown stops accepting new calls and waits for running calls to complete
+// until ctx is done, after which all connections are closed.
+func (listener *grpcListener) shutdown(ctx context.Context) error {
+	listener.lock.Lock()
+	server := listener.server
+	listener.lock.Unlock()
+	if server == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+// GetServiceInfo lists the loaded services for the reflection service.
+func (listener *grpcListener) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := make(map[string]grpc.ServiceInfo)
+	for _, method := range listener.services.Load().methods {
+		service := method.Parent().(protoreflect.ServiceDescriptor)
+		serviceInfo := info[string(service.FullName())]
+		serviceInfo.Metadata = service.ParentFile().Path()
+		serviceInfo.Methods = append(serviceInfo.Methods, grpc.MethodInfo{
+			Name:           string(method.Name()),
+			IsClientStream: method.IsStreamingClient(),
+			IsServerStream: method.IsStreamingServer(),
+		})
+		info[string(service.FullName())] = serviceInfo
+	}
+	return info
+}
+
+func (listener *grpcListener) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	return listener.services.Load().files.FindFileByPath(path)
+}
+
+func (listener *grpcListener) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return listener.services.Load().files.FindDescriptorByName(name)
+}
+
+// handle serves a call by passing it to the router of the mocked methods as
+// a POST request with the request message as JSON body.
+func (listener *grpcListener) handle(_ any, stream grpc.ServerStream) error {
+	services := listener.services.Load()
+	fullMethod, _ := grpc.MethodFromServerStream(stream)
+	method, exists := services.methods[fullMethod]
+	if !exists || !services.mocked[fullMethod] {
+		return status.Errorf(codes.Unimplemented, "method %s is not mocked", fullMethod)
+	}
+
+	writer := &grpcWriter{
+		header:   make(http.Header),
+		trailer:  metadata.MD{},
+		stream:   stream,
+		method:   method,
+		services: services,
+	}
+	var body []byte
+	for {
+		message := dynamicpb.NewMessage(method.Input())
+		if err := stream.RecvMsg(message); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		data, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true, Resolver: services.types}.Marshal(message)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to convert request: %v", err)
+		}
+		var value any
+		json.Unmarshal(data, &value)
+		writer.messages = append(writer.messages, value)
+		body = data
+		if !method.IsStreamingClient() {
+			break
+		}
+	}
+
+	request, err := http.NewRequestWithContext(stream.Context(), http.MethodPost, fullMethod, bytes.NewReader(body))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	request.RequestURI = fullMethod
+	request.Header.Set("Content-Type", "application/json")
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || key == "content-type" {
+			continue
+		}
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	entry, _, request := journalRequest(writer, request)
+	services.router.ServeHTTP(writer, request)
+
+	err = writer.finish()
+	if err != nil && entry.Error == "" {
+		entry.Error = "grpc: " + status.Convert(err).Code().String() + ": " + status.Convert(err).Message()
+	}
+	// journaled like the HTTP response of the call, see grpcHTTPStatus
+	code := status.Code(err)
+	writer.header.Set("Grpc-Status", strconv.Itoa(int(code)))
+	httpStatus := grpcHTTPStatus(code)
+	if writer.status >= 400 && code == grpcCodeFromHTTP(writer.status) {
+		// the status of an error response written by the actions
+		httpStatus = writer.status
+	}
+	listener.journal.finish(entry, &journalResponseWriter{
+		ResponseWriter: writer,
+		status:         httpStatus,
+		body:           bytes.NewBufferString(strings.Join(writer.sent, "\n")),
+	})
+	return err
+}
+
+// grpcWriter is the response of the actions of a gRPC call. Headers are sent
+// as metadata, a body written by other actions is the reply and HTTP error
+// statuses are converted to gRPC status codes.
+type grpcWriter struct {
+	header   http.Header
+	trailer  metadata.MD
+	stream   grpc.ServerStream
+	method   protoreflect.MethodDescriptor
+	services *grpcServices
+	// decoded request messages, more than one for client streams
+	messages []any
+
+	status     int
+	body       bytes.Buffer
+	reply      proto.Message
+	err        error
+	headerSent bool
+	// JSON of the sent messages, for the journal
+	sent []string
+}
+
+func (writer *grpcWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *grpcWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+}
+
+func (writer *grpcWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	return writer.body.Write(data)
+}
+
+// Started reports whether a reply or status has been set, e.g. by the
+// grpc-response action.
+func (writer *grpcWriter) Started() bool {
+	return writer.status != 0 || writer.reply != nil || writer.err != nil
+}
+
+// enrichContext adds the request messages to the context of the actions.
+func (writer *grpcWriter) enrichContext(context map[string]interface{}) {
+	if len(writer.messages) > 0 {
+		last := writer.messages[len(writer.messages)-1]
+		text, _ := json.Marshal(last)
+		context["__message__"] = map[string]interface{}{"json": last, "text": string(text)}
+	}
+	if writer.method.IsStreamingClient() {
+		context["__messages__"] = writer.messages
+	}
+}
+
+// decode converts a JSON or YAML reply into the output message of the method.
+func (writer *grpcWriter) decode(data []byte) (proto.Message, error) {
+	reply := dynamicpb.NewMessage(writer.method.Output())
+	if len(bytes.TrimSpace(data)) == 0 {
+		return reply, nil
+	}
+	if !json.Valid(data) {
+		var value any
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	if err := (protojson.UnmarshalOptions{Resolver: writer.services.types}).Unmarshal(data, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// send sends a reply, preceded by the header metadata if not sent yet.
+func (writer *grpcWriter) send(reply proto.Message) error {
+	writer.sendHeader()
+	if err := writer.stream.SendMsg(reply); err != nil {
+		return err
+	}
+	data, _ := protojson.MarshalOptions{Resolver: writer.services.types}.Marshal(reply)
+	writer.sent = append(writer.sent, string(data))
+	return nil
+}
+
+func (writer *grpcWriter) sendHeader() {
+	if writer.headerSent {
+		return
+	}
+	writer.headerSent = true
+	md := metadata.MD{}
+	for key, values := range writer.header {
+		// reserved by the transport, e.g. set by the response action
+		if key = strings.ToLower(key); key == "content-type" || key == "content-length" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		md.Append(key, values...)
+	}
+	writer.stream.SetHeader(md)
+}
+
+// finish sends the reply of unary and client streaming methods and returns
+// the status of the call.
+func (writer *grpcWriter) finish() error {
+	writer.stream.SetTrailer(writer.trailer)
+	if writer.err == nil && writer.status >= 400 {
+		writer.err = status.Error(grpcCodeFromHTTP(writer.status), failureMessage(writer.body.Bytes()))
+	}
+	writer.sendHeader()
+	if writer.err != nil {
+		return writer.err
+	}
+	reply := writer.reply
+	if reply == nil && (writer.body.Len() > 0 || !writer.method.IsStreamingServer()) {
+		var err error
+		if reply, err = writer.decode(writer.body.Bytes()); err != nil {
+			return status.Errorf(codes.Internal, "invalid response for %s: %v", writer.method.Output().FullName(), err)
+		}
+	}
+	if reply != nil {
+		return writer.send(reply)
+	}
+	return nil
+}
+
+// failureMessage extracts the message of an error response body, which is
+// an ActionError for failed actions.
+func failureMessage(body []byte) string {
+	failure := &ActionError{}
+	if json.Unmarshal(body, failure) == nil && failure.Message != "" {
+		return failure.Error()
+	}
+	return string(body)
+}
+
+// grpcCodeFromHTTP converts the HTTP status of an error response written by
+// the actions into a gRPC status code.
+func grpcCodeFromHTTP(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// grpcHTTPStatus converts a gRPC status code into the HTTP status journaled
+// for the call, following the mapping of the gRPC HTTP gateway.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// parseGrpcCode reads a status code given by number or by name, e.g.
+// `NOT_FOUND` or `NotFound`.
+func parseGrpcCode(value string) (codes.Code, error) {
+	if number, err := strconv.Atoi(value); err == nil {
+		if number < 0 || number > int(codes.Unauthenticated) {
+			return 0, fmt.Errorf("invalid grpc status code %d", number)
+		}
+		return codes.Code(number), nil
+	}
+	name := strings.ToLower(strings.ReplaceAll(value, "_", ""))
+	if name == "cancelled" {
+		// spelling of the gRPC specification
+		return codes.Canceled, nil
+	}
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if strings.ToLower(code.String()) == name {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid grpc status code '%s'", value)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testGrpcProto = `
+syntax = "proto3";
+package shop;
+
+message GetOrderRequest {
+  string order_id = 1;
+  string note = 2;
+}
+
+message Order {
+  string id = 1;
+  string note = 2;
+  int32 items = 3;
+}
+
+service Orders {
+  rpc GetOrder(GetOrderRequest) returns (Order);
+}
+`
+
+const testGrpcConfig = `
+server:
+  port: 8080
+  grpc:
+    port: 9090
+    protos: [shop.proto]
+    endpoints:
+      - method: shop.Orders/GetOrder
+        match:
+          body:
+            order_id: "42"
+        actions:
+          - type: grpc-response
+            params:
+              code: NOT_FOUND
+              message: order {{.__message__.json.order_id}} is gone
+      - method: shop.Orders/GetOrder
+        actions:
+          - type: grpc-response
+            params:
+              headers:
+                - x-request-id: '{{index .__headers__ "X-Request-Id"}}'
+              trailers:
+                - x-served-by: dummyserver
+              body:
+                id: '{{index .__message__.json "order_id"}}'
+                note: '{{.__message__.json.note}}'
+                items: 3
+`
+
+// startTestGrpc serves the gRPC listener of the config.yaml of files and
+// returns a client connection to it.
+func startTestGrpc(t *testing.T, files map[string]string) (*dummyServer, *grpc.ClientConn) {
+	t.Helper()
+	server := newTestServer(t, files)
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.grpc.serve(netListener, nil)
+	t.Cleanup(func() { server.grpc.shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(netListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, conn
+}
+
+func TestGrpcUnaryCall(t *testing.T) {
+	server, conn := startTestGrpc(t, map[string]string{
+		"config.yaml": testGrpcConfig,
+		"shop.proto":  testGrpcProto,
+	})
+	method := server.grpc.services.Load().methods["/shop.Orders/GetOrder"]
+	call := func(orderId string, note string) (*dynamicpb.Message, metadata.MD, metadata.MD, error) {
+		request := dynamicpb.NewMessage(method.Input())
+		request.Set(method.Input().Fields().ByName("order_id"), protoreflect.ValueOfString(orderId))
+		request.Set(method.Input().Fields().ByName("note"), protoreflect.ValueOfString(note))
+		reply := dynamicpb.NewMessage(method.Output())
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1")
+		var header, trailer metadata.MD
+		err := conn.Invoke(ctx, "/shop.Orders/GetOrder", request, reply, grpc.Header(&header), grpc.Trailer(&trailer))
+		return reply, header, trailer, err
+	}
+
+	// values with quotes and newlines are escaped in the reply
+	note := "say \"hi\"\nbye"
+	reply, header, trailer, err := call("7", note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := method.Output().Fields()
+	if id := reply.Get(fields.ByName("id")).String(); id != "7" {
+		t.Errorf("got id %q", id)
+	}
+	if got := reply.Get(fields.ByName("note")).String(); got != note {
+		t.Errorf("got note %q, want %q", got, note)
+	}
+	if items := reply.Get(fields.ByName("items")).Int(); items != 3 {
+		t.Errorf("got items %d", items)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("got header x-request-id %v", got)
+	}
+	if got := trailer.Get("x-served-by"); len(got) != 1 || got[0] != "dummyserver" {
+		t.Errorf("got trailer x-served-by %v", got)
+	}
+
+	_, _, _, err = call("42", "")
+	if status.Code(err) != codes.NotFound || status.Convert(err).Message() != "order 42 is gone" {
+		t.Errorf("got error %v, want NOT_FOUND", err)
+	}
+	if entries := server.journal.Find(&journalFilter{}); len(entries) != 2 || entries[1].Response.Status != 404 {
+		t.Errorf("got journal entries %+v", entries)
+	}
+}
+
+func TestGrpcRejectsServerFaults(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.yaml": `
+server:
+  port: 8080
+  fault:
+    error: {status: 503}
+  grpc:
+    port: 9090
+    protos: [shop.proto]
+`,
+		"shop.proto": testGrpcProto,
+	})
+	if _, err := loadConfig(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), "server faults are not supported") {
+		t.Errorf("got error %v, want unsupported server faults", err)
+	}
+}
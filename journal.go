@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bodies are stored in the journal up to this size only.
+const journalMaxBodySize = 1024 * 1024
+
+type JournalEntry struct {
+	Id        string            `json:"id" yaml:"id"`
+	Time      time.Time         `json:"time" yaml:"time"`
+	Method    string            `json:"method" yaml:"method"`
+	Url       string            `json:"url" yaml:"url"`
+	Params    map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+	Headers   http.Header       `json:"headers" yaml:"headers"`
+	Body      string            `json:"body" yaml:"body"`
+	Endpoint  string            `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Response  JournalResponse   `json:"response" yaml:"response"`
+	Duration  float64           `json:"durationMs" yaml:"durationMs"`
+	Truncated bool              `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+	// failure of an action while handling the request
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type JournalResponse struct {
+	Status  int         `json:"status" yaml:"status"`
+	Headers http.Header `json:"headers" yaml:"headers"`
+	Body    string      `json:"body" yaml:"body"`
+}
+
+// journal keeps the most recent requests received by a server.
+type journal struct {
+	lock    sync.RWMutex
+	entries []*JournalEntry
+	limit   int
+}
+
+func newJournal(limit int) *journal {
+	return &journal{limit: limit}
+}
+
+// SetLimit caps the number of retained entries; limit <= 0 keeps all.
+func (j *journal) SetLimit(limit int) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.limit = limit
+	j.truncate()
+}
+
+func (j *journal) truncate() {
+	if j.limit > 0 && len(j.entries) > j.limit {
+		j.entries = append([]*JournalEntry{}, j.entries[len(j.entries)-j.limit:]...)
+	}
+}
+
+func (j *journal) Add(entry *JournalEntry) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.entries = append(j.entries, entry)
+	j.truncate()
+}
+
+func (j *journal) Clear() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.entries = nil
+}
+
+func (j *journal) Get(id string) *JournalEntry {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	for _, entry := range j.entries {
+		if entry.Id == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Find returns all entries matching filter in the order they were received.
+func (j *journal) Find(filter *journalFilter) []*JournalEntry {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	result := []*JournalEntry{}
+	for _, entry := range j.entries {
+		if filter.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// journalFilter selects journal entries. Empty fields match everything.
+type journalFilter struct {
+	Method      string            `json:"method" yaml:"method"`
+	Url         string            `json:"url" yaml:"url"`
+	UrlPattern  string            `json:"urlPattern" yaml:"urlPattern"`
+	Endpoint    string            `json:"endpoint" yaml:"endpoint"`
+	BodyPattern string            `json:"bodyPattern" yaml:"bodyPattern"`
+	Headers     map[string]string `json:"headers" yaml:"headers"`
+	Status      int               `json:"status" yaml:"status"`
+
+	urlRegexp     *regexp.Regexp
+	bodyRegexp    *regexp.Regexp
+	headerRegexps map[string]*regexp.Regexp
+}
+
+// compile prepares the patterns of the filter.
+func (filter *journalFilter) compile() (err error) {
+	if filter.UrlPattern != "" {
+		if filter.urlRegexp, err = regexp.Compile(filter.UrlPattern); err != nil {
+			return err
+		}
+	}
+	if filter.BodyPattern != "" {
+		if filter.bodyRegexp, err = regexp.Compile(filter.BodyPattern); err != nil {
+			return err
+		}
+	}
+	filter.headerRegexps = make(map[string]*regexp.Regexp, len(filter.Headers))
+	for name, pattern := range filter.Headers {
+		if filter.headerRegexps[name], err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (filter *journalFilter) matches(entry *JournalEntry) bool {
+	if filter.Method != "" && !strings.EqualFold(filter.Method, entry.Method) {
+		return false
+	}
+	if filter.Url != "" && filter.Url != entry.Url {
+		return false
+	}
+	if filter.urlRegexp != nil && !filter.urlRegexp.MatchString(entry.Url) {
+		return false
+	}
+	if filter.Endpoint != "" && filter.Endpoint != entry.Endpoint {
+		return false
+	}
+	if filter.bodyRegexp != nil && !filter.bodyRegexp.MatchString(entry.Body) {
+		return false
+	}
+	for name, pattern := range filter.headerRegexps {
+		if !pattern.MatchString(strings.Join(entry.Headers.Values(name), ", ")) {
+			return false
+		}
+	}
+	if filter.Status != 0 && filter.Status != entry.Response.Status {
+		return false
+	}
+	return true
+}
+
+type journalContextKey struct{}
+
+// journalEntryOf returns the journal entry of a request received by a
+// dummyServer, or nil if the request is not journaled.
+func journalEntryOf(request *http.Request) *JournalEntry {
+	entry, _ := request.Context().Value(journalContextKey{}).(*JournalEntry)
+	return entry
+}
+
+// journalRequest creates a new journal entry for request. Up to
+// journalMaxBodySize bytes of the body are buffered for the entry, the request
+// body is replaced by the buffered bytes followed by the unread remainder.
+// The returned response writer captures the response for the entry.
+func journalRequest(response http.ResponseWriter, request *http.Request) (*JournalEntry, *journalResponseWriter, *http.Request) {
+	entry := &JournalEntry{
+		Id:      uuid.Must(uuid.NewRandom()).String(),
+		Time:    time.Now(),
+		Method:  request.Method,
+		Url:     request.URL.RequestURI(),
+		Headers: request.Header.Clone(),
+	}
+	if request.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(request.Body, journalMaxBodySize+1))
+		request.Body = &journalRequestBody{
+			Reader: io.MultiReader(bytes.NewReader(body), request.Body),
+			Closer: request.Body,
+		}
+		entry.Body, entry.Truncated = truncateBody(body)
+	}
+	request = request.WithContext(context.WithValue(request.Context(), journalContextKey{}, entry))
+	return entry, &journalResponseWriter{ResponseWriter: response, body: &bytes.Buffer{}}, request
+}
+
+// finish completes entry with the captured response and adds it to the
+// journal.
+func (j *journal) finish(entry *JournalEntry, response *journalResponseWriter) {
+	entry.Duration = float64(time.Since(entry.Time).Microseconds()) / 1000
+	entry.Response.Status = response.status
+	entry.Response.Headers = response.Header().Clone()
+	body, truncated := truncateBody(response.body.Bytes())
+	entry.Response.Body = body
+	entry.Truncated = entry.Truncated || truncated
+	j.Add(entry)
+}
+
+func truncateBody(body []byte) (string, bool) {
+	if len(body) > journalMaxBodySize {
+		return string(body[:journalMaxBodySize]), true
+	}
+	return string(body), false
+}
+
+// journalRequestBody reads the buffered start of a request body and then the
+// remainder from the original body, which is closed by Close.
+type journalRequestBody struct {
+	io.Reader
+	io.Closer
+}
+
+// journalStatus records status as the response status of a request whose
+// connection was hijacked, e.g. by a websocket upgrade.
+func journalStatus(response http.ResponseWriter, status int) {
+	for {
+		switch writer := response.(type) {
+		case *journalResponseWriter:
+			writer.status = status
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			response = writer.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// journalResponseWriter captures status and body written to the wrapped
+// response writer.
+type journalResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (writer *journalResponseWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *journalResponseWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	if writer.body.Len() < journalMaxBodySize+1 {
+		writer.body.Write(data[:min(len(data), journalMaxBodySize+1-writer.body.Len())])
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *journalResponseWriter) Started() bool {
+	return writer.status != 0
+}
+
+func (writer *journalResponseWriter) Flush() {
+	http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+func (writer *journalResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(writer.ResponseWriter).Hijack()
+}
+
+func (writer *journalResponseWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestJournalConcurrentAccess(t *testing.T) {
+	j := newJournal(50)
+	filter := &journalFilter{Method: http.MethodGet}
+	if err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wait sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wait.Add(1)
+		go func(worker int) {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				id := fmt.Sprintf("%d-%d", worker, i)
+				j.Add(&JournalEntry{Id: id, Method: http.MethodGet})
+				j.Get(id)
+				j.Find(filter)
+				if i%25 == 0 {
+					j.SetLimit(50 + worker)
+				}
+			}
+		}(worker)
+	}
+	wait.Wait()
+
+	if count := len(j.Find(&journalFilter{})); count < 50 || count > 57 {
+		t.Errorf("journal keeps %d entries, want the limit", count)
+	}
+	j.Clear()
+	if count := len(j.Find(&journalFilter{})); count != 0 {
+		t.Errorf("journal keeps %d entries after clear", count)
+	}
+}
+
+func TestJournalFilter(t *testing.T) {
+	entry := &JournalEntry{
+		Method:   http.MethodPost,
+		Url:      "/users/1?full=true",
+		Headers:  http.Header{"X-Test": {"run-7"}},
+		Body:     `{"name": "bob"}`,
+		Response: JournalResponse{Status: http.StatusCreated},
+	}
+	tests := []struct {
+		filter journalFilter
+		want   bool
+	}{
+		{journalFilter{}, true},
+		{journalFilter{Method: "post", Status: http.StatusCreated}, true},
+		{journalFilter{UrlPattern: `^/users/\d+`}, true},
+		{journalFilter{Url: "/users/1"}, false},
+		{journalFilter{BodyPattern: `"name": "bob"`}, true},
+		{journalFilter{Headers: map[string]string{"X-Test": "^run-"}}, true},
+		{journalFilter{Headers: map[string]string{"X-Missing": "."}}, false},
+		{journalFilter{Status: http.StatusOK}, false},
+	}
+	for i, test := range tests {
+		if err := test.filter.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got := test.filter.matches(entry); got != test.want {
+			t.Errorf("filter #%d matches %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestJournalRequestKeepsLargeBodies(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), journalMaxBodySize+100)
+	request := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body))
+	entry, _, request := journalRequest(httptest.NewRecorder(), request)
+
+	if len(entry.Body) != journalMaxBodySize || !entry.Truncated {
+		t.Errorf("journaled %d bytes (truncated %v), want %d", len(entry.Body), entry.Truncated, journalMaxBodySize)
+	}
+	read, err := io.ReadAll(request.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, body) {
+		t.Errorf("handler reads %d bytes, want %d", len(read), len(body))
+	}
+}
+
+func TestJournalRecordsResponse(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /created
+    method: POST
+    name: create
+    actions:
+      - type: response
+        params: {status: 201, body: done}
+`})
+	serveTest(server, http.MethodPost, "/created", "payload", nil)
+
+	entries := server.journal.Find(&journalFilter{})
+	if len(entries) != 1 {
+		t.Fatalf("journaled %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Endpoint != "create" || entry.Body != "payload" ||
+		entry.Response.Status != http.StatusCreated || entry.Response.Body != "done" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schemaValidator validates values against a subset of JSON Schema, including
+// the OpenAPI 3.0 extensions `nullable` and boolean exclusive limits. Local
+// references are resolved within doc.
+type schemaValidator struct {
+	doc *openapiDocument
+}
+
+var (
+	schemaPatterns     = map[string]*regexp.Regexp{}
+	schemaPatternsLock sync.Mutex
+	schemaUUIDPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// validate returns the violations of schema by value, each prefixed by the
+// path of the violating value.
+func (validator schemaValidator) validate(schemaValue interface{}, value interface{}, path string) []string {
+	if schemaValue == true || schemaValue == nil {
+		return nil
+	}
+	if schemaValue == false {
+		return []string{path + ": not allowed"}
+	}
+	schema := validator.doc.resolve(schemaValue)
+	if schema == nil {
+		return nil
+	}
+	errs := []string{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil && schema["nullable"] == true {
+		return nil
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, typ := range types {
+			matched = matched || schemaTypeMatches(typ, value)
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(types, " or "), schemaTypeOf(value))
+			return errs
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			found = found || schemaEqual(candidate, value)
+		}
+		if !found {
+			fail("must be one of %s", jsonValueString(enum))
+		}
+	}
+	if constant, exists := schema["const"]; exists && !schemaEqual(constant, value) {
+		fail("must be %s", jsonValueString(constant))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, exists := value[fmt.Sprint(name)]; !exists {
+				fail("missing required property '%v'", name)
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if propertySchema, exists := properties[key]; exists {
+				errs = append(errs, validator.validate(propertySchema, value[key], path+"."+key)...)
+			} else if additional, exists := schema["additionalProperties"]; exists {
+				if additional == false {
+					fail("unexpected property '%s'", key)
+				} else {
+					errs = append(errs, validator.validate(additional, value[key], path+"."+key)...)
+				}
+			}
+		}
+		if limit, ok := schemaNumber(schema["minProperties"]); ok && float64(len(value)) < limit {
+			fail("must have at least %v properties", limit)
+		}
+		if limit, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(value)) > limit {
+			fail("must have at most %v properties", limit)
+		}
+	case []interface{}:
+		if limit, ok := schemaNumber(schema["minItems"]); ok && float64(len(value)) < limit {
+			fail("must have at least %v items", limit)
+		}
+		if limit, ok := schemaNumber(schema["maxItems"]); ok && float64(len(value)) > limit {
+			fail("must have at most %v items", limit)
+		}
+		if schema["uniqueItems"] == true {
+			for i := range value {
+				for j := 0; j < i; j++ {
+					if schemaEqual(value[i], value[j]) {
+						fail("items %d and %d are equal", j, i)
+					}
+				}
+			}
+		}
+		if items, exists := schema["items"]; exists {
+			for i, item := range value {
+				errs = append(errs, validator.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		length := float64(len([]rune(value)))
+		if limit, ok := schemaNumber(schema["minLength"]); ok && length < limit {
+			fail("must be at least %v characters long", limit)
+		}
+		if limit, ok := schemaNumber(schema["maxLength"]); ok && length > limit {
+			fail("must be at most %v characters long", limit)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if expression, err := compileSchemaPattern(pattern); err != nil {
+				fail("invalid pattern: %v", err)
+			} else if !expression.MatchString(value) {
+				fail("must match pattern %s", pattern)
+			}
+		}
+		if format, ok := schema["format"].(string); ok && !schemaFormatMatches(format, value) {
+			fail("must be a valid %s", format)
+		}
+	default:
+		if number, ok := schemaNumber(value); ok {
+			validator.validateNumber(schema, number, fail)
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, part := range allOf {
+			errs = append(errs, validator.validate(part, value, path)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, alternative := range anyOf {
+			matched = matched || len(validator.validate(alternative, value, path)) == 0
+		}
+		if !matched {
+			fail("must match any of the alternatives")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, alternative := range oneOf {
+			if len(validator.validate(alternative, value, path)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one of the alternatives, matched %d", matches)
+		}
+	}
+	if not, exists := schema["not"]; exists && len(validator.validate(not, value, path)) == 0 {
+		fail("must not match the schema")
+	}
+	return errs
+}
+
+func (validator schemaValidator) validateNumber(schema map[string]interface{}, number float64, fail func(string, ...interface{})) {
+	if limit, ok := schemaNumber(schema["minimum"]); ok {
+		if schema["exclusiveMinimum"] == true && number <= limit {
+			fail("must be greater than %v", limit)
+		} else if number < limit {
+			fail("must be at least %v", limit)
+		}
+	}
+	if limit, ok := schemaNumber(schema["maximum"]); ok {
+		if schema["exclusiveMaximum"] == true && number >= limit {
+			fail("must be less than %v", limit)
+		} else if number > limit {
+			fail("must be at most %v", limit)
+		}
+	}
+	if limit, ok := schemaNumber(schema["exclusiveMinimum"]); ok && number <= limit {
+		fail("must be greater than %v", limit)
+	}
+	if limit, ok := schemaNumber(schema["exclusiveMaximum"]); ok && number >= limit {
+		fail("must be less than %v", limit)
+	}
+	if factor, ok := schemaNumber(schema["multipleOf"]); ok && factor > 0 {
+		if quotient := number / factor; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			fail("must be a multiple of %v", factor)
+		}
+	}
+}
+
+// coerce converts text, e.g. a query param, to the type declared by schema.
+// Text which cannot be converted is returned as is and fails validation.
+func (validator schemaValidator) coerce(schemaValue interface{}, text string) interface{} {
+	schema := validator.doc.resolve(schemaValue)
+	for _, typ := range schemaTypes(schema["type"]) {
+		switch typ {
+		case "integer", "number":
+			if number, err := strconv.ParseFloat(text, 64); err == nil {
+				return number
+			}
+		case "boolean":
+			if boolean, err := strconv.ParseBool(text); err == nil {
+				return boolean
+			}
+		case "null":
+			if text == "" {
+				return nil
+			}
+		}
+	}
+	return text
+}
+
+// coerceAll converts repeated values, e.g. of a query param, to an array if
+// schema declares one, else to the type of the first value.
+func (validator schemaValidator) coerceAll(schemaValue interface{}, texts []string) interface{} {
+	schema := validator.doc.resolve(schemaValue)
+	for _, typ := range schemaTypes(schema["type"]) {
+		if typ == "array" {
+			items := make([]interface{}, 0, len(texts))
+			for _, text := range texts {
+				for _, part := range strings.Split(text, ",") {
+					items = append(items, validator.coerce(schema["items"], part))
+				}
+			}
+			return items
+		}
+	}
+	return validator.coerce(schema, texts[0])
+}
+
+func schemaTypes(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		types := make([]string, 0, len(value))
+		for _, typ := range value {
+			types = append(types, fmt.Sprint(typ))
+		}
+		return types
+	}
+	return nil
+}
+
+func schemaTypeMatches(typ string, value interface{}) bool {
+	switch typ {
+	case "integer":
+		number, ok := schemaNumber(value)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := schemaNumber(value)
+		return ok
+	}
+	return schemaTypeOf(value) == typ
+}
+
+func schemaTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := schemaNumber(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// schemaNumber converts the numbers decoded from JSON and YAML to float64.
+func schemaNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// schemaEqual compares values regardless of the types of their numbers.
+func schemaEqual(a interface{}, b interface{}) bool {
+	if numberA, ok := schemaNumber(a); ok {
+		numberB, ok := schemaNumber(b)
+		return ok && numberA == numberB
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func schemaFormatMatches(format string, value string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, value)
+		return err == nil
+	case "email":
+		address, err := mail.ParseAddress(value)
+		return err == nil && address.Address == value
+	case "uuid":
+		return schemaUUIDPattern.MatchString(value)
+	case "uri", "url":
+		parsed, err := url.Parse(value)
+		return err == nil && parsed.Scheme != ""
+	}
+	// unknown formats are annotations only
+	return true
+}
+
+// compilePatterns compiles the request schemas returned by requestSchemas, so
+// that invalid patterns fail the setup instead of every validated request.
+func (validator schemaValidator) compilePatterns(schemas map[string]interface{}) error {
+	visited := map[string]bool{}
+	for _, part := range []string{"params", "query", "headers", "body"} {
+		if schema, exists := schemas[part]; exists {
+			if err := validator.compileSchemaPatterns(schema, part, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compileSchemaPatterns compiles the patterns of schema and of all schemas
+// below it. Each reference is only followed once.
+func (validator schemaValidator) compileSchemaPatterns(schemaValue interface{}, path string, visited map[string]bool) error {
+	if schemaMap, _ := schemaValue.(map[string]interface{}); schemaMap != nil {
+		if ref, isRef := schemaMap["$ref"].(string); isRef {
+			if visited[ref] {
+				return nil
+			}
+			visited[ref] = true
+		}
+	}
+	schema := validator.doc.resolve(schemaValue)
+	if schema == nil {
+		return nil
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, err := compileSchemaPattern(pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", path, err)
+		}
+	}
+	type child struct {
+		path   string
+		schema interface{}
+	}
+	children := []child{}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			children = append(children, child{path + "." + name, properties[name]})
+		}
+	}
+	if items, exists := schema["items"]; exists {
+		children = append(children, child{path + "[]", items})
+	}
+	for _, keyword := range []string{"additionalProperties", "not"} {
+		if value, exists := schema[keyword]; exists {
+			children = append(children, child{path, value})
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		parts, _ := schema[keyword].([]interface{})
+		for _, part := range parts {
+			children = append(children, child{path, part})
+		}
+	}
+	for _, child := range children {
+		if err := validator.compileSchemaPatterns(child.schema, child.path, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileSchemaPattern(pattern string) (*regexp.Regexp, error) {
+	schemaPatternsLock.Lock()
+	defer schemaPatternsLock.Unlock()
+	if expression, exists := schemaPatterns[pattern]; exists {
+		return expression, nil
+	}
+	expression, err := regexp.Compile(pattern)
+	if err == nil {
+		schemaPatterns[pattern] = expression
+	}
+	return expression, err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseTestSchema parses a YAML schema document like an OpenAPI document.
+func parseTestSchema(t *testing.T, source string) schemaValidator {
+	t.Helper()
+	var root interface{}
+	if err := yaml.Unmarshal([]byte(source), &root); err != nil {
+		t.Fatal(err)
+	}
+	return schemaValidator{doc: &openapiDocument{root: normalizeYAML(root).(map[string]interface{})}}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	validator := parseTestSchema(t, `
+components:
+  schemas:
+    Pet:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name: {type: string, minLength: 1, pattern: "^[a-z]+$"}
+        age: {type: integer, minimum: 0, exclusiveMaximum: 50}
+        tags: {type: array, items: {type: string}, uniqueItems: true, maxItems: 2}
+        owner: {type: string, format: email, nullable: true}
+        kind: {enum: [cat, dog]}
+`)
+	schema := map[string]interface{}{"$ref": "#/components/schemas/Pet"}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{
+		{"valid", map[string]interface{}{"name": "rex", "age": 3, "tags": []interface{}{"a"}, "owner": nil, "kind": "dog"}, []string{}},
+		{"wrong type", "rex", []string{"pet: expected object, got string"}},
+		{"missing required", map[string]interface{}{}, []string{"pet: missing required property 'name'"}},
+		{"unexpected property", map[string]interface{}{"name": "rex", "color": "red"}, []string{"pet: unexpected property 'color'"}},
+		{"string constraints", map[string]interface{}{"name": "Rex"}, []string{"pet.name: must match pattern ^[a-z]+$"}},
+		{"number constraints", map[string]interface{}{"name": "rex", "age": 50.0}, []string{"pet.age: must be less than 50"}},
+		{"not an integer", map[string]interface{}{"name": "rex", "age": 1.5}, []string{"pet.age: expected integer, got number"}},
+		{"array constraints", map[string]interface{}{"name": "rex", "tags": []interface{}{"a", "b", "a"}}, []string{
+			"pet.tags: must have at most 2 items",
+			"pet.tags: items 0 and 2 are equal",
+		}},
+		{"format", map[string]interface{}{"name": "rex", "owner": "nobody"}, []string{"pet.owner: must be a valid email"}},
+		{"enum", map[string]interface{}{"name": "rex", "kind": "bird"}, []string{`pet.kind: must be one of ["cat","dog"]`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := validator.validate(schema, test.value, "pet"); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSchemaCombinators(t *testing.T) {
+	validator := parseTestSchema(t, "{}")
+	schema := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "integer", "not": map[string]interface{}{"const": 0}},
+		},
+	}
+	for _, value := range []interface{}{"text", 5} {
+		if errs := validator.validate(schema, value, "v"); len(errs) > 0 {
+			t.Errorf("%v: unexpected violations %q", value, errs)
+		}
+	}
+	for _, value := range []interface{}{0, true} {
+		if errs := validator.validate(schema, value, "v"); len(errs) == 0 {
+			t.Errorf("%v: no violations", value)
+		}
+	}
+}
+
+func TestSchemaCoerce(t *testing.T) {
+	validator := parseTestSchema(t, "{}")
+	tests := []struct {
+		schema map[string]interface{}
+		texts  []string
+		want   interface{}
+	}{
+		{map[string]interface{}{"type": "integer"}, []string{"42"}, 42.0},
+		{map[string]interface{}{"type": "boolean"}, []string{"true"}, true},
+		{map[string]interface{}{"type": "integer"}, []string{"abc"}, "abc"},
+		{map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "number"}}, []string{"1,2", "3"}, []interface{}{1.0, 2.0, 3.0}},
+		{map[string]interface{}{"type": "string"}, []string{"a", "b"}, "a"},
+	}
+	for _, test := range tests {
+		if got := validator.coerceAll(test.schema, test.texts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("coerceAll(%v, %q) = %#v, want %#v", test.schema, test.texts, got, test.want)
+		}
+	}
+}
+
+func TestSchemaCompilePatterns(t *testing.T) {
+	validator := parseTestSchema(t, `
+components:
+  schemas:
+    Node:
+      type: object
+      properties:
+        name: {type: string, pattern: "^[a-z]+$"}
+        children: {type: array, items: {$ref: "#/components/schemas/Node"}}
+    Tag:
+      allOf:
+        - {type: string}
+        - {pattern: "[a-"}
+`)
+	// recursive schemas are compiled once
+	valid := map[string]interface{}{"body": map[string]interface{}{"$ref": "#/components/schemas/Node"}}
+	if err := validator.compilePatterns(valid); err != nil {
+		t.Errorf("got error %v", err)
+	}
+
+	invalid := map[string]interface{}{"body": map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"tags": map[string]interface{}{"items": map[string]interface{}{"$ref": "#/components/schemas/Tag"}}},
+	}}
+	if err := validator.compilePatterns(invalid); err == nil || !strings.HasPrefix(err.Error(), "body.tags[]: invalid pattern") {
+		t.Errorf("got error %v, want an invalid pattern of body.tags[]", err)
+	}
+}
+
+func TestValidateRejectsInvalidPatterns(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /items
+    method: GET
+    actions:
+      - type: validate
+        params:
+          query:
+            type: object
+            properties:
+              id: {type: string, pattern: "(unclosed"}
+`})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDummyServer(&cfg.Servers[0]); err == nil || !strings.Contains(err.Error(), "query.id: invalid pattern") {
+		t.Errorf("got error %v, want an invalid pattern", err)
+	}
+}
@@ -2,146 +2,268 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
-	"gopkg.in/yaml.v3"
 )
 
-// webserver config
-type Config struct {
-	Server struct {
-		Ip   string
-		Port int
-	}
-	Endpoints []EndpointStruct
-}
-
 type EndpointStruct struct {
 	// Endpoint URL format:
 	// https://godoc.org/github.com/julienschmidt/httprouter
-	Url     string
-	Method  string // Accepts GET and POST
-	Actions []ActionStruct
+	Url     string         `json:"url"`
+	Method  Methods        `json:"method"` // Single method, list or ANY
+	// Optional name identifying the endpoint in the request journal
+	Name    string         `json:"name,omitempty" yaml:",omitempty"`
+	// Optional predicates to tell apart endpoints sharing method and url
+	Match   *MatchStruct   `json:"match,omitempty" yaml:",omitempty"`
+	// Optional cache namespace of the actions of this endpoint, e.g.
+	// `{{index .__headers__ "X-Test-Id"}}` to scope state per test run
+	Namespace string `json:"namespace,omitempty" yaml:",omitempty"`
+	Actions []ActionStruct `json:"actions"`
+	// Actions run instead of the default error response when an action fails,
+	// the failure is available as `__error__` in their context
+	OnError []ActionStruct `json:"onError,omitempty" yaml:"onError,omitempty"`
+	// Optional WebSocket events, the actions above run before the upgrade
+	Websocket *WebsocketStruct `json:"websocket,omitempty" yaml:",omitempty"`
+	// Optional GraphQL schema, the actions above run before the operation
+	Graphql *GraphqlStruct `json:"graphql,omitempty" yaml:",omitempty"`
 	Params  struct {
 		// Parser string // optional, "json" or "yaml", default is none
-	}
+	} `json:"-" yaml:"-"`
 }
 
 type ActionStruct struct {
-	Type   string
-	Params map[string]interface{}
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty" yaml:",omitempty"`
 }
 
 var (
 	globalContext = NewCache(func(key string, value any) {})
-	fileCache = NewCache(func(key string, value any) {
-		value.(CacheFile).Remove()
-	})
+	fileCache = NewCache(removeCacheFile)
 	actionProviderMap = map[string]ActionHandlerProvider{}
 )
 
 // initialize handlers etc
 func main() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func(){
-		<-c
-		signal.Stop(c)
-		fileCache.Clear()
-		os.Exit(0)
-	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	defer func(){
 		// ensure temp files are cleaned up
 		r := recover()
-		fileCache.Clear()
+		closeCaches()
 		if r != nil {
 			panic(r)
 		}
 	}()
+	if len(os.Args) >= 2 && os.Args[1] == "openapi" {
+		if err := runOpenapiCommand(os.Args[2:]); err != nil {
+			log.Println("OpenAPI import error: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	configFile := "dummyserver.yaml"
 	if len(os.Args) >= 2 {
 		configFile = os.Args[1]
 	}
-	bytes, err := os.ReadFile(configFile)
-	if err != nil {
-		log.Fatalf("Error reading dummyserver.yaml: %s", err.Error())
-	}
-	// Parse config.
-	cfg := &Config{}
-	err = yaml.Unmarshal(bytes, cfg)
+	cfg, err := loadConfig(configFile)
 	if err != nil {
 		log.Println("Config parser error: " + err.Error())
 		return
 	}
+	if err := setupCaches(&cfg.Cache); err != nil {
+		log.Println("Cache setup error: " + err.Error())
+		return
+	}
 
-	// Create router.
-	router := httprouter.New()
-
-	// Create handlers.
-	for _, endpoint := range cfg.Endpoints {
-		log.Println(".")
-		switch endpoint.Method {
-		case "GET":
-			router.GET(endpoint.Url, newEndpointHandler(endpoint))
-		case "POST":
-			router.POST(endpoint.Url, newEndpointHandler(endpoint))
-		case "PUT":
-			router.PUT(endpoint.Url, newEndpointHandler(endpoint))
-		default:
-			log.Fatalf("Unsupported endpoint method type '%s' for %s", endpoint.Method, endpoint.Url)
+	// Create servers.
+	servers := make([]*dummyServer, 0, len(cfg.Servers))
+	for i := range cfg.Servers {
+		server, err := newDummyServer(&cfg.Servers[i])
+		if err != nil {
+			// log.Fatal would skip the cleanup of the caches
+			log.Printf("Server %s setup error: %v", cfg.Servers[i].Name, err)
+			closeCaches()
+			os.Exit(1)
 		}
-		log.Printf(" `-> [%s] %s", endpoint.Method, endpoint.Url)
+		servers = append(servers, server)
 	}
+	go watchConfig(configFile, cfg, servers, time.Second)
 
 	// Bind to ip and port.
-	ip := cfg.Server.Ip
-	port := cfg.Server.Port
-	addr := fmt.Sprintf("%s:%d", ip, port)
 	log.Println()
-	log.Printf("Binding to: %s\n\n", addr)
-	log.Fatalln(http.ListenAndServe(addr, router))
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *dummyServer) {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				errs <- fmt.Errorf("server %s: %w", server.name, err)
+			}
+		}(server)
+	}
+
+	status := 0
+	select {
+	case err := <-errs:
+		log.Println(err)
+		status = 1
+	case sig := <-signals:
+		// a second signal terminates immediately
+		signal.Stop(signals)
+		timeout := 30 * time.Second
+		if cfg.ShutdownTimeout > 0 {
+			timeout = time.Duration(cfg.ShutdownTimeout) * time.Second
+		}
+		log.Printf("Received %s, draining in-flight requests (timeout %s)", sig, timeout)
+		if !shutdownServers(servers, timeout) {
+			status = 1
+		}
+	}
+	closeCaches()
+	os.Exit(status)
+}
+
+// shutdownServers gracefully shuts down all servers in parallel and reports
+// whether all in-flight requests completed within timeout.
+func shutdownServers(servers []*dummyServer, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := make(chan bool, len(servers))
+	for _, server := range servers {
+		go func(server *dummyServer) {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Server %s: requests still in flight after %s, closing connections: %v", server.name, timeout, err)
+				results <- false
+			} else {
+				results <- true
+			}
+		}(server)
+	}
+	drained := true
+	for range servers {
+		drained = <-results && drained
+	}
+	if drained {
+		log.Println("All servers shut down")
+	}
+	return drained
 }
 
 type ActionHandler func(requestId string, response http.ResponseWriter, request *http.Request, params httprouter.Params, context map[string]interface{})
 
-func newEndpointHandler(endpoint EndpointStruct) func(http.ResponseWriter, *http.Request, httprouter.Params) {
-	actionHandlers := createActionHandlers(endpoint)
+func newEndpointHandler(endpoint EndpointStruct, server *ServerConfig) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	actionHandlers := createActionHandlers(endpoint, endpoint.Actions)
+	errorHandlers := createActionHandlers(endpoint, endpoint.OnError)
+	var websocket *websocketEndpoint
+	if endpoint.Websocket != nil {
+		websocket = newWebsocketEndpoint(endpoint, server.Name, errorHandlers)
+	}
+	var graphql *graphqlEndpoint
+	if endpoint.Graphql != nil {
+		graphql = newGraphqlEndpoint(endpoint)
+	}
 	return func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 		var (
 			requestId  = uuid.Must(uuid.NewRandom()).String()
 			paramMap   = make(map[string]string)
 			context map[string]interface{}
+			action  string
 		)
+		if entry := journalEntryOf(request); entry != nil {
+			requestId = entry.Id
+			entry.Endpoint = endpoint.Method.String() + " " + endpoint.Url
+			if endpoint.Name != "" {
+				entry.Endpoint = endpoint.Name
+			}
+			entry.Params = paramMap
+		}
 		log.Printf("[%s] %s %s [size=%d]", requestId, request.Method, request.RequestURI, request.ContentLength)
 		for _, param := range params {
 			paramMap[param.Key] = param.Value
 		}
 		context = map[string]interface{}{
-			"params": paramMap,
+			"params":      paramMap,
+			"__headers__": requestHeaders(request),
+			"__cookies__": requestCookies(request),
+		}
+		if writer, isGrpc := response.(*grpcWriter); isGrpc {
+			writer.enrichContext(context)
+		}
+		// finished after the recovery below, which may still write an error response
+		if writer, answered := applyFault(server.Fault, requestId, response, request); answered {
+			return
+		} else if writer != nil {
+			defer writer.finish()
+			response = writer
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				failure := actionFailureOf(r, requestId, endpoint, action)
+				handleActionFailure(failure, server.ErrorStatus, errorHandlers, response, request, params, context)
+			}
+		}()
+		context["__namespace__"] = fromTemplate(endpoint.Namespace, context)
+		if err := validateNamespace(context["__namespace__"].(string)); err != nil {
+			panic(err)
 		}
-		for _, action := range actionHandlers {
-			action(requestId, response, request, params, context)
+		global := newGlobalSnapshot(context["__namespace__"].(string))
+		for i, handler := range actionHandlers {
+			action = endpoint.Actions[i].Type
+			// refreshed to reflect changes of earlier actions
+			context["__global__"] = global.get()
+			handler(requestId, response, request, params, context)
+		}
+		// the actions may have rejected the upgrade with a response
+		if websocket != nil && !responseStarted(response) {
+			websocket.serve(requestId, response, request, params, context)
+		}
+		if graphql != nil && !responseStarted(response) {
+			graphql.serve(requestId, response, request, params, context)
+		}
+	}
+}
+
+// requestHeaders returns the first value of each request header by its
+// canonical name.
+func requestHeaders(request *http.Request) map[string]string {
+	headers := make(map[string]string, len(request.Header))
+	for key, values := range request.Header {
+		if len(values) > 0 {
+			headers[key] = values[0]
 		}
 	}
+	return headers
+}
+
+func requestCookies(request *http.Request) map[string]string {
+	cookies := make(map[string]string)
+	for _, cookie := range request.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
 }
 
 type ActionHandlerProvider func(endpoint EndpointStruct, actionParams map[string]any) ActionHandler
 
-func createActionHandlers(endpoint EndpointStruct) []ActionHandler {
-	actionHandlers := make([]ActionHandler, 0, len(endpoint.Actions))
-	for _, action := range endpoint.Actions {
+func createActionHandlers(endpoint EndpointStruct, actions []ActionStruct) []ActionHandler {
+	actionHandlers := make([]ActionHandler, 0, len(actions))
+	for _, action := range actions {
 		log.Printf("attempting to add action %v", action)
 		if actionProvider, exists := actionProviderMap[action.Type]; exists {
 			actionHandlers = append(actionHandlers, actionProvider(endpoint, action.Params))
 		} else {
-			log.Fatalf("Unsupported action type '%s'", action.Type)
+			log.Panicf("Unsupported action type '%s'", action.Type)
 		}
 	}
 	return actionHandlers
@@ -161,6 +283,28 @@ func fromTemplate(tpl string, data map[string]interface{}) string {
 	return buf.String()
 }
 
+// renderTemplates renders all strings of a structured config value, so that
+// templated values can be marshalled without escaping the templates.
+func renderTemplates(value interface{}, context map[string]interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return fromTemplate(value, context)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			rendered[key] = renderTemplates(item, context)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, item := range value {
+			rendered[i] = renderTemplates(item, context)
+		}
+		return rendered
+	}
+	return value
+}
+
 func actionPanic(
 	requestId string,
 	endpoint EndpointStruct,
@@ -168,12 +312,18 @@ func actionPanic(
 	fmtString string,
 	fmtParams ...interface{},
 ) {
-	log.Panicf("[%s] >> ERROR << [%s|%s] action:%s\n%s",
+	failure := &ActionError{
+		RequestId: requestId,
+		Action:    action,
+		Message:   fmt.Sprintf(fmtString, fmtParams...),
+	}
+	log.Printf("[%s] >> ERROR << [%s|%s] action:%s\n%s",
 		requestId,
 		endpoint.Method,
 		endpoint.Url,
 		action,
-		fmt.Sprintf(fmtString, fmtParams...))
+		failure.Message)
+	panic(failure)
 }
 
 func actionError(
@@ -219,7 +369,7 @@ func actionSetupPanic(
 	fmtString string,
 	fmtParams ...interface{},
 ) {
-	log.Fatalf("| >> SETUP ERROR << [%s|%s] action:%s\n -> %s",
+	log.Panicf("| >> SETUP ERROR << [%s|%s] action:%s\n -> %s",
 		endpoint.Method, endpoint.Url,
 		action,
 		fmt.Sprintf(fmtString, fmtParams...))
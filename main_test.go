@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testShutdownConfig = `
+endpoints:
+  - url: /slow
+    method: GET
+    actions:
+      - type: response
+        params: {body: done, delay: 300}
+  - url: /stuck
+    method: GET
+    actions:
+      - type: response
+        params: {body: done, delay: 5000}
+`
+
+// listenTestServer serves server like ListenAndServe, but on a random port,
+// and returns its url.
+func listenTestServer(t *testing.T, server *dummyServer) string {
+	t.Helper()
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.lock.Lock()
+	server.httpServer = &http.Server{Handler: server}
+	server.httpServer.RegisterOnShutdown(server.drain.start)
+	httpServer := server.httpServer
+	server.lock.Unlock()
+	go httpServer.Serve(netListener)
+	t.Cleanup(func() { httpServer.Close() })
+	return "http://" + netListener.Addr().String()
+}
+
+// getTestUrl requests url in the background and sends its body, or the error,
+// once done.
+func getTestUrl(url string) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		result <- string(body)
+	}()
+	return result
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	servers := []*dummyServer{
+		newTestServer(t, map[string]string{"config.yaml": testShutdownConfig}),
+		newTestServer(t, map[string]string{"config.yaml": testShutdownConfig}),
+	}
+	urls := []string{listenTestServer(t, servers[0]), listenTestServer(t, servers[1])}
+	results := []<-chan string{getTestUrl(urls[0] + "/slow"), getTestUrl(urls[1] + "/slow")}
+	// lets the requests arrive before shutting down
+	time.Sleep(50 * time.Millisecond)
+
+	if !shutdownServers(servers, 5*time.Second) {
+		t.Error("in-flight requests were not drained")
+	}
+	for i, result := range results {
+		if body := <-result; body != "done" {
+			t.Errorf("request to server %d: got %q, want done", i, body)
+		}
+	}
+	if _, err := http.Get(urls[0] + "/slow"); err == nil {
+		t.Error("new request accepted after the shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	servers := []*dummyServer{
+		newTestServer(t, map[string]string{"config.yaml": testShutdownConfig}),
+		// servers which never listened shut down right away
+		newTestServer(t, map[string]string{"config.yaml": testShutdownConfig}),
+	}
+	url := listenTestServer(t, servers[0])
+	result := getTestUrl(url + "/stuck")
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if shutdownServers(servers, 100*time.Millisecond) {
+		t.Error("reported drained with a request still in flight")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+	// the remaining connections are closed
+	if body := <-result; body == "done" {
+		t.Error("request completed after the shutdown timeout")
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// MatchStruct restricts an endpoint to requests satisfying all of its
+// predicates, so that several variants of the same route can coexist.
+type MatchStruct struct {
+	// Variants with a higher priority are tried first, equal priorities are
+	// ordered by the number of predicates (most specific first).
+	Priority int `json:"priority,omitempty" yaml:",omitempty"`
+	// Path params of the route, e.g. to serve `/users/me` next to `/users/:id`
+	Params  map[string]Matcher `json:"params,omitempty" yaml:",omitempty"`
+	Query   map[string]Matcher `json:"query,omitempty" yaml:",omitempty"`
+	Headers map[string]Matcher `json:"headers,omitempty" yaml:",omitempty"`
+	Cookies map[string]Matcher `json:"cookies,omitempty" yaml:",omitempty"`
+	Form    map[string]Matcher `json:"form,omitempty" yaml:",omitempty"`
+	// JSON paths into the request body, e.g. `user.roles[0]`; `$` matches
+	// against the raw body
+	Body map[string]Matcher `json:"body,omitempty" yaml:",omitempty"`
+	// Required state of a scenario, in the namespace of the endpoint
+	Scenario *ScenarioMatch `json:"scenario,omitempty" yaml:",omitempty"`
+}
+
+// Matcher is a predicate on a single value. A plain string in the config is
+// read as Equals.
+type Matcher struct {
+	Equals   string `json:"equals,omitempty" yaml:",omitempty"`
+	Contains string `json:"contains,omitempty" yaml:",omitempty"`
+	Regex    string `json:"regex,omitempty" yaml:",omitempty"`
+	// Absent requires the value to be missing
+	Absent bool `json:"absent,omitempty" yaml:",omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+func (matcher *Matcher) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		matcher.Equals = node.Value
+		return nil
+	}
+	type plainMatcher Matcher
+	return node.Decode((*plainMatcher)(matcher))
+}
+
+func (matcher *Matcher) compile() (err error) {
+	if matcher.Regex != "" {
+		matcher.regexp, err = regexp.Compile(matcher.Regex)
+	}
+	return err
+}
+
+// matches reports whether any of values satisfies the matcher.
+func (matcher *Matcher) matches(values []string, present bool) bool {
+	if matcher.Absent {
+		return !present
+	}
+	if !present {
+		return false
+	}
+	for _, value := range values {
+		if (matcher.Equals == "" || value == matcher.Equals) &&
+			(matcher.Contains == "" || strings.Contains(value, matcher.Contains)) &&
+			(matcher.regexp == nil || matcher.regexp.MatchString(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileMatchers(matchers map[string]Matcher) (map[string]Matcher, error) {
+	compiled := make(map[string]Matcher, len(matchers))
+	for key, matcher := range matchers {
+		if err := matcher.compile(); err != nil {
+			return nil, fmt.Errorf("invalid matcher for '%s': %w", key, err)
+		}
+		compiled[key] = matcher
+	}
+	return compiled, nil
+}
+
+// compile returns a copy of match with compiled matchers. The config itself
+// is left untouched, as routers still serving requests share it.
+func (match *MatchStruct) compile() (*MatchStruct, error) {
+	compiled := *match
+	if compiled.Scenario != nil && strings.Contains(compiled.Scenario.Name, namespaceSeparator) {
+		return nil, fmt.Errorf("invalid scenario name '%s': must not contain '%s'", compiled.Scenario.Name, namespaceSeparator)
+	}
+	for _, matchers := range []*map[string]Matcher{&compiled.Params, &compiled.Query, &compiled.Headers, &compiled.Cookies, &compiled.Form, &compiled.Body} {
+		var err error
+		if *matchers, err = compileMatchers(*matchers); err != nil {
+			return nil, err
+		}
+	}
+	return &compiled, nil
+}
+
+// specificity is the number of predicates of match.
+func (match *MatchStruct) specificity() int {
+	if match == nil {
+		return 0
+	}
+	specificity := len(match.Params) + len(match.Query) + len(match.Headers) + len(match.Cookies) + len(match.Form) + len(match.Body)
+	if match.Scenario != nil {
+		specificity++
+	}
+	return specificity
+}
+
+// normalized returns a representation of match which is equal for match
+// blocks accepting the same requests, e.g. differing only in the case of
+// header names.
+func (match *MatchStruct) normalized() string {
+	if match == nil {
+		return ""
+	}
+	normalized := *match
+	normalized.Headers = make(map[string]Matcher, len(match.Headers))
+	for key, matcher := range match.Headers {
+		normalized.Headers[http.CanonicalHeaderKey(key)] = matcher
+	}
+	data, _ := json.Marshal(&normalized)
+	return string(data)
+}
+
+func (match *MatchStruct) priority() int {
+	if match == nil {
+		return 0
+	}
+	return match.Priority
+}
+
+// matchRequest lazily parses the parts of a request needed for matching.
+type matchRequest struct {
+	request  *http.Request
+	params   httprouter.Params
+	body     []byte
+	bodyRead bool
+	form     map[string][]string
+	jsonBody any
+	jsonErr  error
+	jsonRead bool
+}
+
+func (mr *matchRequest) readBody() []byte {
+	if !mr.bodyRead {
+		mr.bodyRead = true
+		if mr.request.Body != nil {
+			mr.body, _ = io.ReadAll(mr.request.Body)
+			mr.request.Body.Close()
+			mr.request.Body = io.NopCloser(bytes.NewReader(mr.body))
+		}
+	}
+	return mr.body
+}
+
+func (mr *matchRequest) readForm() map[string][]string {
+	if mr.form == nil {
+		// parse a copy, so that the request body stays available to actions
+		clone := mr.request.Clone(mr.request.Context())
+		clone.Body = io.NopCloser(bytes.NewReader(mr.readBody()))
+		if err := clone.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+			log.Printf("match: failed to parse form: %v", err)
+		}
+		if clone.MultipartForm != nil {
+			clone.MultipartForm.RemoveAll()
+		}
+		mr.form = clone.Form
+		if mr.form == nil {
+			mr.form = map[string][]string{}
+		}
+	}
+	return mr.form
+}
+
+func (mr *matchRequest) readJSON() (any, error) {
+	if !mr.jsonRead {
+		mr.jsonRead = true
+		decoder := json.NewDecoder(bytes.NewReader(mr.readBody()))
+		decoder.UseNumber()
+		mr.jsonErr = decoder.Decode(&mr.jsonBody)
+	}
+	return mr.jsonBody, mr.jsonErr
+}
+
+// namespace resolves the namespace template of an endpoint for the request.
+func (mr *matchRequest) namespace(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	paramMap := make(map[string]string)
+	for _, param := range mr.params {
+		paramMap[param.Key] = param.Value
+	}
+	return fromTemplate(namespace, map[string]interface{}{
+		"params":      paramMap,
+		"__headers__": requestHeaders(mr.request),
+		"__cookies__": requestCookies(mr.request),
+	})
+}
+
+// matches reports whether the request satisfies all predicates of match.
+// Scenarios are looked up in the given endpoint namespace.
+func (match *MatchStruct) matches(mr *matchRequest, namespace string) bool {
+	if match == nil {
+		return true
+	}
+	if scenario := match.Scenario; scenario != nil {
+		// an invalid namespace fails the actions of the endpoint instead
+		namespace := mr.namespace(namespace)
+		if validateNamespace(namespace) != nil ||
+			scenarios.State(namespacedKey(namespace, scenario.Name)) != scenario.State {
+			return false
+		}
+	}
+	for key, matcher := range match.Params {
+		value := mr.params.ByName(key)
+		if !matcher.matches([]string{value}, value != "") {
+			return false
+		}
+	}
+	request := mr.request
+	query := request.URL.Query()
+	for key, matcher := range match.Query {
+		values, present := query[key]
+		if !matcher.matches(values, present) {
+			return false
+		}
+	}
+	for key, matcher := range match.Headers {
+		values := request.Header.Values(key)
+		if !matcher.matches(values, len(values) > 0) {
+			return false
+		}
+	}
+	for key, matcher := range match.Cookies {
+		cookie, err := request.Cookie(key)
+		if err != nil {
+			if !matcher.matches(nil, false) {
+				return false
+			}
+		} else if !matcher.matches([]string{cookie.Value}, true) {
+			return false
+		}
+	}
+	if len(match.Form) > 0 {
+		form := mr.readForm()
+		for key, matcher := range match.Form {
+			values, present := form[key]
+			if !matcher.matches(values, present) {
+				return false
+			}
+		}
+	}
+	for path, matcher := range match.Body {
+		if path == "$" {
+			if !matcher.matches([]string{string(mr.readBody())}, len(mr.readBody()) > 0) {
+				return false
+			}
+			continue
+		}
+		data, err := mr.readJSON()
+		if err != nil {
+			if !matcher.Absent {
+				return false
+			}
+			continue
+		}
+		value, present := jsonPathLookup(data, path)
+		if !matcher.matches([]string{jsonValueString(value)}, present) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonPathLookup resolves a simple JSON path like `$.user.roles[0]` or
+// `user.roles.0` in data.
+func jsonPathLookup(data any, path string) (any, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.ReplaceAll(strings.ReplaceAll(path, "[", "."), "]", "")
+	if path == "" {
+		return data, true
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch value := data.(type) {
+		case map[string]any:
+			var exists bool
+			if data, exists = value[part]; !exists {
+				return nil, false
+			}
+		case []any:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			data = value[index]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// jsonValueString formats a decoded JSON value for matching: scalars as their
+// plain text, objects and arrays as JSON.
+func jsonValueString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	case json.Number, bool:
+		return fmt.Sprint(v)
+	default:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	}
+}
+
+type routeVariant struct {
+	match *MatchStruct
+	// namespace template of the endpoint
+	namespace string
+	// registered via `ANY` instead of an explicit method
+	wildcard bool
+	order    int
+	handler  httprouter.Handle
+}
+
+// newRouteHandler dispatches requests to the first variant whose match block
+// is satisfied. Variants are ordered by priority, then specificity, then
+// explicit methods before `ANY`, then definition order. Requests matching no
+// variant are passed to notFound.
+func newRouteHandler(variants []routeVariant, notFound http.Handler) httprouter.Handle {
+	sort.SliceStable(variants, func(i, j int) bool {
+		if pi, pj := variants[i].match.priority(), variants[j].match.priority(); pi != pj {
+			return pi > pj
+		}
+		if si, sj := variants[i].match.specificity(), variants[j].match.specificity(); si != sj {
+			return si > sj
+		}
+		if variants[i].wildcard != variants[j].wildcard {
+			return !variants[i].wildcard
+		}
+		return variants[i].order < variants[j].order
+	})
+	if notFound == nil {
+		notFound = http.NotFoundHandler()
+	}
+	return func(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		mr := &matchRequest{request: request, params: params}
+		for _, variant := range variants {
+			if variant.match.matches(mr, variant.namespace) {
+				variant.handler(response, request, params)
+				return
+			}
+		}
+		notFound.ServeHTTP(response, request)
+	}
+}
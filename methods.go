@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// All methods an endpoint may be registered for.
+var standardMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	http.MethodConnect, http.MethodTrace,
+}
+
+// Methods are the HTTP methods of an endpoint. In the config they may be given
+// as a single method or as a list. `ANY` (or `*`) stands for all standard
+// methods.
+type Methods []string
+
+func (methods *Methods) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*methods = Methods{node.Value}
+		return nil
+	}
+	list := []string{}
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*methods = list
+	return nil
+}
+
+func (methods Methods) MarshalYAML() (interface{}, error) {
+	if len(methods) == 1 {
+		return methods[0], nil
+	}
+	return []string(methods), nil
+}
+
+func (methods Methods) MarshalJSON() ([]byte, error) {
+	if len(methods) == 1 {
+		return json.Marshal(methods[0])
+	}
+	return json.Marshal([]string(methods))
+}
+
+func (methods Methods) String() string {
+	return strings.Join(methods, ",")
+}
+
+func isWildcardMethod(method string) bool {
+	return method == "*" || strings.EqualFold(method, "ANY")
+}
+
+// IsWildcard reports whether methods contains `ANY`.
+func (methods Methods) IsWildcard() bool {
+	for _, method := range methods {
+		if isWildcardMethod(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the distinct standard methods denoted by methods.
+func (methods Methods) Expand() ([]string, error) {
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no endpoint method specified")
+	}
+	expanded := []string{}
+	seen := make(map[string]bool)
+	for _, method := range methods {
+		candidates := []string{strings.ToUpper(method)}
+		if isWildcardMethod(method) {
+			candidates = standardMethods
+		} else if !isStandardMethod(candidates[0]) {
+			return nil, fmt.Errorf("unsupported endpoint method type '%s'", method)
+		}
+		for _, candidate := range candidates {
+			if !seen[candidate] {
+				seen[candidate] = true
+				expanded = append(expanded, candidate)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+func isStandardMethod(method string) bool {
+	for _, standard := range standardMethods {
+		if method == standard {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyOf reports whether any of methods is contained in allowed.
+func (methods Methods) AnyOf(allowed map[string]any) bool {
+	expanded, _ := methods.Expand()
+	for _, method := range expanded {
+		if _, contains := allowed[method]; contains {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMethodsExpand(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+		err    string
+	}{
+		{"get", "GET", ""},
+		{"[GET, post, GET]", "GET,POST", ""},
+		{"ANY", strings.Join(standardMethods, ","), ""},
+		{"['*', GET]", strings.Join(standardMethods, ","), ""},
+		{"[DELETE, any]", "DELETE," + strings.Join(standardMethods[:5], ",") + "," + strings.Join(standardMethods[6:], ","), ""},
+		{"[]", "", "no endpoint method specified"},
+		{"[GET, FETCH]", "", "unsupported endpoint method type 'FETCH'"},
+	}
+	for _, test := range tests {
+		t.Run(test.config, func(t *testing.T) {
+			var methods Methods
+			if err := yaml.Unmarshal([]byte(test.config), &methods); err != nil {
+				t.Fatal(err)
+			}
+			expanded, err := methods.Expand()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(expanded, ","); got != test.want {
+				t.Errorf("expanded to %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMethodsAnyOf(t *testing.T) {
+	allowed := map[string]any{http.MethodPost: nil, http.MethodPut: nil}
+	tests := []struct {
+		methods Methods
+		want    bool
+	}{
+		{Methods{"post"}, true},
+		{Methods{"GET", "PUT"}, true},
+		{Methods{"GET", "DELETE"}, false},
+		{Methods{"ANY"}, true},
+		// invalid methods never match
+		{Methods{"POST", "FETCH"}, false},
+		{Methods{}, false},
+	}
+	for _, test := range tests {
+		if got := test.methods.AnyOf(allowed); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.methods, got, test.want)
+		}
+	}
+}
+
+func TestMethodListsAndWildcards(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /items
+    method: ANY
+    actions:
+      - type: response
+        params: {body: any}
+  - url: /items
+    method: [GET, post]
+    actions:
+      - type: response
+        params: {body: listed}
+`})
+
+	// listed methods take precedence over ANY
+	for method, want := range map[string]string{
+		http.MethodGet:    "listed",
+		http.MethodPost:   "listed",
+		http.MethodDelete: "any",
+	} {
+		if status, body := serveTest(server, method, "/items", "", nil); status != http.StatusOK || body != want {
+			t.Errorf("%s: got %d %q, want 200 %q", method, status, body, want)
+		}
+	}
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenapiImport generates endpoints for all operations of an OpenAPI 3
+// document. Endpoints of the config take precedence over generated ones.
+type OpenapiImport struct {
+	// YAML or JSON document, relative to the config file
+	Spec string
+	// Prefix of all generated urls, e.g. `/v1`
+	Prefix string
+	// Validate requests against the operations before responding
+	Validate bool
+}
+
+var openapiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openapiPathParam matches the templated segments of OpenAPI paths.
+var openapiPathParam = regexp.MustCompile(`^\{([^{}/]+)\}$`)
+
+// maximum length of reference chains
+const openapiMaxDepth = 8
+
+// openapiDocument is a parsed OpenAPI document whose local references can be
+// resolved.
+type openapiDocument struct {
+	root map[string]interface{}
+}
+
+func loadOpenapiDocument(path string) (*openapiDocument, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON documents are valid YAML as well
+	var root interface{}
+	if err := yaml.Unmarshal(bytes, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rootMap, ok := normalizeYAML(root).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: not an OpenAPI document", path)
+	}
+	if version, _ := rootMap["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%s: unsupported OpenAPI version '%v', expected 3.x", path, rootMap["openapi"])
+	}
+	return &openapiDocument{root: rootMap}, nil
+}
+
+// importOpenapi generates endpoints responding to every operation of the
+// document at path. Operations whose route is already defined by existing
+// endpoints are skipped. Static segments next to params, e.g. `/pets/mine`
+// next to `/pets/{id}`, are served as variants of the param route matching
+// the static value. Other conflicts with the routes of existing endpoints
+// fail the import.
+func importOpenapi(path string, prefix string, validate bool, existing []EndpointStruct) ([]EndpointStruct, error) {
+	doc, err := loadOpenapiDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := doc.root["paths"].(map[string]interface{})
+	urls := make([]string, 0, len(paths))
+	for url := range paths {
+		urls = append(urls, url)
+	}
+	// templated segments first, so that the params at each position are
+	// known before their static siblings
+	sort.Slice(urls, func(i, j int) bool {
+		return strings.ReplaceAll(urls[i], "{", "\x00") < strings.ReplaceAll(urls[j], "{", "\x00")
+	})
+
+	// routes are tried on a router of their own, which panics on conflicts
+	router := httprouter.New()
+	routed := make(map[string]bool)
+	tryRoute := func(method string, url string) (err error) {
+		if routed[method+" "+url] {
+			return nil
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		router.Handle(method, url, func(http.ResponseWriter, *http.Request, httprouter.Params) {})
+		routed[method+" "+url] = true
+		return nil
+	}
+	defined := make(map[string]bool)
+	paramNames := make(map[string]string)
+	for _, endpoint := range existing {
+		methods, _ := endpoint.Method.Expand()
+		for _, method := range methods {
+			defined[method+" "+endpoint.Url] = true
+			tryRoute(method, endpoint.Url)
+		}
+		segments := strings.Split(endpoint.Url, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				paramNames[strings.Join(segments[:i], "/")] = segment[1:]
+			}
+		}
+	}
+
+	endpoints := []EndpointStruct{}
+	for _, openapiUrl := range urls {
+		pathItem := doc.resolve(paths[openapiUrl])
+		url, fixed, err := openapiRoute(prefix, openapiUrl, paramNames)
+		if err != nil {
+			log.Printf("| OpenAPI %s: skipping %s: %v", path, openapiUrl, err)
+			continue
+		}
+		var match *MatchStruct
+		if len(fixed) > 0 {
+			match = &MatchStruct{Params: make(map[string]Matcher, len(fixed))}
+			for name, value := range fixed {
+				match.Params[name] = Matcher{Equals: value}
+			}
+		}
+		for _, method := range openapiMethods {
+			operation := doc.resolve(pathItem[method])
+			if operation == nil {
+				continue
+			}
+			method = strings.ToUpper(method)
+			if match == nil && defined[method+" "+url] {
+				log.Printf("| OpenAPI %s: skipping [%s] %s, defined by the config", path, method, url)
+				continue
+			}
+			if err := tryRoute(method, url); err != nil {
+				return nil, fmt.Errorf("%s: [%s] %s cannot be served next to the other routes: %v", path, method, openapiUrl, err)
+			}
+			name, _ := operation["operationId"].(string)
+			actions := []ActionStruct{}
+			if validate {
+				schemas, _ := doc.requestSchemas(pathItem, operation)
+				if err := (schemaValidator{doc: doc}).compilePatterns(schemas); err != nil {
+					return nil, fmt.Errorf("%s: [%s] %s: %v", path, method, openapiUrl, err)
+				}
+				actions = append(actions, doc.validateActions(path, method, openapiUrl, operation)...)
+			}
+			endpoints = append(endpoints, EndpointStruct{
+				Url:     url,
+				Method:  Methods{method},
+				Name:    name,
+				Match:   match,
+				Actions: append(actions, ActionStruct{Type: "response", Params: doc.responseParams(operation)}),
+			})
+		}
+	}
+	log.Printf("| OpenAPI %s: generated %d endpoints", path, len(endpoints))
+	return endpoints, nil
+}
+
+// openapiRoute translates an OpenAPI path to the syntax of the router, e.g.
+// `/pets/{id}` to `/pets/:id`. The router requires the same name for all
+// params at the same position, so the first name seen there is kept in
+// paramNames and used by later paths. Static segments at the position of a
+// param are turned into that param, their values are returned by name.
+func openapiRoute(prefix string, openapiUrl string, paramNames map[string]string) (string, map[string]string, error) {
+	segments := strings.Split(strings.TrimSuffix(prefix, "/")+openapiUrl, "/")
+	fixed := map[string]string{}
+	for i, segment := range segments {
+		position := strings.Join(segments[:i], "/")
+		name, exists := paramNames[position]
+		if match := openapiPathParam.FindStringSubmatch(segment); match != nil {
+			if !exists {
+				name = match[1]
+				paramNames[position] = name
+			} else if name != match[1] {
+				log.Printf("| OpenAPI path %s: param '%s' is served as '%s'", openapiUrl, match[1], name)
+			}
+			segments[i] = ":" + name
+		} else if strings.ContainsAny(segment, "{}:*") {
+			return "", nil, fmt.Errorf("segment '%s' is not supported by the router", segment)
+		} else if exists && segment != "" {
+			fixed[name] = segment
+			segments[i] = ":" + name
+		}
+	}
+	return strings.Join(segments, "/"), fixed, nil
+}
+
+// responseParams returns the params of a response action answering with the
+// success response of operation, its body taken from the examples of the
+// document or generated from the schema.
+func (doc *openapiDocument) responseParams(operation map[string]interface{}) map[string]interface{} {
+	responses := doc.resolve(operation["responses"])
+	status, response := selectOpenapiResponse(responses)
+	params := map[string]interface{}{"status": status, "body": ""}
+	content, _ := doc.resolve(response)["content"].(map[string]interface{})
+	if len(content) == 0 {
+		return params
+	}
+
+	contentType := preferredMediaType(content)
+	media := doc.resolve(content[contentType])
+
+	var body interface{}
+	if example, exists := media["example"]; exists {
+		body = example
+	} else if examples, _ := media["examples"].(map[string]interface{}); len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		body = doc.resolve(examples[names[0]])["value"]
+	} else {
+		body = doc.fakeValue(media["schema"], "", map[string]bool{})
+	}
+
+	var text string
+	switch {
+	case body == nil:
+	case strings.Contains(contentType, "json"):
+		bytes, _ := json.MarshalIndent(body, "", "  ")
+		text = string(bytes)
+	case strings.Contains(contentType, "yaml"):
+		bytes, _ := yaml.Marshal(body)
+		text = string(bytes)
+	default:
+		text = jsonValueString(body)
+	}
+	params["headers"] = []interface{}{map[string]interface{}{"Content-Type": contentType}}
+	params["body"] = escapeTemplate(text)
+	return params
+}
+
+// validateActions returns the actions validating requests to an operation,
+// parsing required JSON and YAML bodies first. Optional bodies are not
+// validated, the parse actions fail requests without body.
+func (doc *openapiDocument) validateActions(spec string, method string, openapiUrl string, operation map[string]interface{}) []ActionStruct {
+	actions := []ActionStruct{}
+	requestBody := doc.resolve(operation["requestBody"])
+	content, _ := requestBody["content"].(map[string]interface{})
+	if len(content) > 0 && requestBody["required"] == true &&
+		(method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete) {
+		switch mediaType := preferredMediaType(content); {
+		case strings.Contains(mediaType, "json"):
+			actions = append(actions, ActionStruct{Type: "parse-json"})
+		case strings.Contains(mediaType, "yaml"):
+			actions = append(actions, ActionStruct{Type: "parse-yaml"})
+		}
+	}
+	return append(actions, ActionStruct{Type: "validate", Params: map[string]interface{}{
+		"openapi": spec,
+		"method":  method,
+		"path":    openapiUrl,
+	}})
+}
+
+// preferredMediaType returns the first JSON media type of content, else the
+// first one.
+func preferredMediaType(content map[string]interface{}) string {
+	mediaTypes := make([]string, 0, len(content))
+	for mediaType := range content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	for _, mediaType := range mediaTypes {
+		if strings.Contains(mediaType, "json") {
+			return mediaType
+		}
+	}
+	return mediaTypes[0]
+}
+
+// selectOpenapiResponse returns the first success response, else the default
+// response, else the first one.
+func selectOpenapiResponse(responses map[string]interface{}) (int, interface{}) {
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			if status, err := strconv.Atoi(code); err == nil {
+				return status, responses[code]
+			}
+			return http.StatusOK, responses[code]
+		}
+	}
+	if response, exists := responses["default"]; exists {
+		return http.StatusOK, response
+	}
+	for _, code := range codes {
+		if status, err := strconv.Atoi(code); err == nil {
+			return status, responses[code]
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// resolve follows the local `$ref` of value, if any. External references are
+// not supported and resolve to nil.
+func (doc *openapiDocument) resolve(value interface{}) map[string]interface{} {
+	for depth := 0; depth < openapiMaxDepth; depth++ {
+		valueMap, _ := value.(map[string]interface{})
+		ref, isRef := valueMap["$ref"].(string)
+		if !isRef {
+			return valueMap
+		}
+		if !strings.HasPrefix(ref, "#/") {
+			log.Printf("| OpenAPI reference %s not supported", ref)
+			return nil
+		}
+		value = doc.root
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			parent, _ := value.(map[string]interface{})
+			value = parent[token]
+		}
+	}
+	return nil
+}
+
+// fakeValue generates a value of schema, preferring its examples, defaults
+// and enums. References already being generated (recursive schemas) are
+// left out.
+func (doc *openapiDocument) fakeValue(schemaValue interface{}, name string, visiting map[string]bool) interface{} {
+	if schemaMap, _ := schemaValue.(map[string]interface{}); schemaMap != nil {
+		if ref, isRef := schemaMap["$ref"].(string); isRef {
+			if visiting[ref] {
+				return nil
+			}
+			visiting[ref] = true
+			defer delete(visiting, ref)
+		}
+	}
+	schema := doc.resolve(schemaValue)
+	if schema == nil {
+		return nil
+	}
+	for _, key := range []string{"example", "default", "const"} {
+		if value, exists := schema[key]; exists {
+			return value
+		}
+	}
+	if examples, _ := schema["examples"].([]interface{}); len(examples) > 0 {
+		return examples[0]
+	}
+	if enum, _ := schema["enum"].([]interface{}); len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf, _ := schema["allOf"].([]interface{}); len(allOf) > 0 {
+		// the properties of object parts are merged, parts without a value
+		// (e.g. only listing required properties) are skipped
+		var merged map[string]interface{}
+		for _, part := range allOf {
+			switch value := doc.fakeValue(part, name, visiting).(type) {
+			case nil:
+			case map[string]interface{}:
+				if merged == nil {
+					merged = map[string]interface{}{}
+				}
+				for key, item := range value {
+					merged[key] = item
+				}
+			default:
+				return value
+			}
+		}
+		if merged == nil {
+			return nil
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alternatives, _ := schema[key].([]interface{}); len(alternatives) > 0 {
+			return doc.fakeValue(alternatives[0], name, visiting)
+		}
+	}
+
+	typ, _ := schema["type"].(string)
+	if types, ok := schema["type"].([]interface{}); ok {
+		// OpenAPI 3.1 lists the types, including null for nullable ones
+		for _, candidate := range types {
+			if candidate != "null" {
+				typ, _ = candidate.(string)
+				break
+			}
+		}
+	}
+	if typ == "" {
+		if _, exists := schema["properties"]; exists {
+			typ = "object"
+		} else if _, exists := schema["items"]; exists {
+			typ = "array"
+		}
+	}
+	switch typ {
+	case "object":
+		properties, _ := schema["properties"].(map[string]interface{})
+		object := make(map[string]interface{}, len(properties))
+		for property, propertySchema := range properties {
+			if value := doc.fakeValue(propertySchema, property, visiting); value != nil {
+				object[property] = value
+			}
+		}
+		return object
+	case "array":
+		count := 1
+		if minItems, ok := schemaNumber(schema["minItems"]); ok && int(minItems) > count {
+			count = int(minItems)
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			if item := doc.fakeValue(schema["items"], name, visiting); item != nil {
+				items = append(items, item)
+			}
+		}
+		return items
+	case "integer":
+		if minimum, ok := schemaNumber(schema["minimum"]); ok {
+			return int(math.Ceil(minimum))
+		}
+		return 1
+	case "number":
+		if minimum, exists := schema["minimum"]; exists {
+			return minimum
+		}
+		return 1.5
+	case "boolean":
+		return true
+	case "string":
+		switch schema["format"] {
+		case "date-time":
+			return "2024-01-01T12:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "email":
+			return "user@example.com"
+		case "uuid":
+			return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+		case "uri", "url":
+			return "https://example.com"
+		case "ipv4":
+			return "192.0.2.1"
+		case "byte":
+			return "ZXhhbXBsZQ=="
+		}
+		if name != "" {
+			return name
+		}
+		return "string"
+	}
+	return nil
+}
+
+// normalizeYAML converts the maps decoded by yaml, whose keys may be numbers
+// (e.g. response codes), to maps with string keys.
+func normalizeYAML(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = normalizeYAML(item)
+		}
+		return value
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return normalized
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeYAML(item)
+		}
+	}
+	return value
+}
+
+// runOpenapiCommand prints the endpoints generated from an OpenAPI document as
+// config, to be included or edited: `dummyserver openapi <spec> [prefix]`.
+func runOpenapiCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: dummyserver openapi <spec> [prefix]")
+	}
+	prefix := ""
+	if len(args) == 2 {
+		prefix = args[1]
+	}
+	endpoints, err := importOpenapi(args[0], prefix, false, nil)
+	if err != nil {
+		return err
+	}
+	bytes, err := yaml.Marshal(map[string]interface{}{"endpoints": endpoints})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(bytes)
+	return err
+}
+
+// openapiRouteParam matches the params of router paths.
+var openapiRouteParam = regexp.MustCompile(`:[^/]+`)
+
+// findOperation returns the path and operation with operationId or else the
+// one of method and path, which default to the method and url of endpoint.
+func (doc *openapiDocument) findOperation(
+	operationId string,
+	method string,
+	path string,
+	endpoint EndpointStruct,
+) (string, map[string]interface{}, map[string]interface{}, error) {
+	paths, _ := doc.root["paths"].(map[string]interface{})
+	if operationId != "" {
+		for openapiUrl, pathValue := range paths {
+			pathItem := doc.resolve(pathValue)
+			for _, candidate := range openapiMethods {
+				if operation := doc.resolve(pathItem[candidate]); operation != nil && operation["operationId"] == operationId {
+					return openapiUrl, pathItem, operation, nil
+				}
+			}
+		}
+		return "", nil, nil, fmt.Errorf("operation '%s' not found", operationId)
+	}
+
+	if method == "" {
+		methods, err := endpoint.Method.Expand()
+		if err != nil || len(methods) != 1 {
+			return "", nil, nil, fmt.Errorf("endpoint serves several methods, specify the operation or method")
+		}
+		method = methods[0]
+	}
+	if path != "" {
+		pathItem := doc.resolve(paths[path])
+		if operation := doc.resolve(pathItem[strings.ToLower(method)]); operation != nil {
+			return path, pathItem, operation, nil
+		}
+		return "", nil, nil, fmt.Errorf("operation [%s] %s not found", method, path)
+	}
+	// paths are compared regardless of the names of their params
+	route := openapiRouteParam.ReplaceAllString(endpoint.Url, ":")
+	for openapiUrl, pathValue := range paths {
+		candidate, _, err := openapiRoute("", openapiUrl, map[string]string{})
+		if err != nil || openapiRouteParam.ReplaceAllString(candidate, ":") != route {
+			continue
+		}
+		pathItem := doc.resolve(pathValue)
+		if operation := doc.resolve(pathItem[strings.ToLower(method)]); operation != nil {
+			return openapiUrl, pathItem, operation, nil
+		}
+	}
+	return "", nil, nil, fmt.Errorf("no operation for [%s] %s, specify the operation or path", method, endpoint.Url)
+}
+
+// openapiParamNames returns the names of the params of openapiUrl by the
+// names of the router params at the same positions of route, which may differ
+// (see openapiRoute). The paths are aligned at their ends, as routes may be
+// prefixed.
+func openapiParamNames(openapiUrl string, route string) map[string]string {
+	names := map[string]string{}
+	segments := strings.Split(strings.Trim(openapiUrl, "/"), "/")
+	routeSegments := strings.Split(strings.Trim(route, "/"), "/")
+	offset := len(routeSegments) - len(segments)
+	for i, segment := range segments {
+		match := openapiPathParam.FindStringSubmatch(segment)
+		if match == nil || i+offset < 0 {
+			continue
+		}
+		if routeSegment := routeSegments[i+offset]; strings.HasPrefix(routeSegment, ":") {
+			names[routeSegment[1:]] = match[1]
+		}
+	}
+	return names
+}
+
+// requestSchemas returns the schemas of the params, query, headers and body
+// of an operation, as validated by the validate action, and whether the body
+// is required.
+func (doc *openapiDocument) requestSchemas(pathItem, operation map[string]interface{}) (map[string]interface{}, bool) {
+	// parameters of the operation override those of the path
+	parameters := map[string]map[string]interface{}{}
+	keys := []string{}
+	for _, list := range []interface{}{pathItem["parameters"], operation["parameters"]} {
+		entries, _ := list.([]interface{})
+		for _, entry := range entries {
+			parameter := doc.resolve(entry)
+			key := fmt.Sprint(parameter["in"], ":", parameter["name"])
+			if _, exists := parameters[key]; !exists {
+				keys = append(keys, key)
+			}
+			parameters[key] = parameter
+		}
+	}
+	schemas := map[string]interface{}{}
+	parts := map[string]string{"path": "params", "query": "query", "header": "headers"}
+	for _, key := range keys {
+		parameter := parameters[key]
+		part, supported := parts[fmt.Sprint(parameter["in"])]
+		if !supported {
+			continue
+		}
+		schema, exists := schemas[part].(map[string]interface{})
+		if !exists {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}, "required": []interface{}{}}
+			schemas[part] = schema
+		}
+		name := fmt.Sprint(parameter["name"])
+		parameterSchema := parameter["schema"]
+		if parameterSchema == nil {
+			parameterSchema = map[string]interface{}{}
+		}
+		schema["properties"].(map[string]interface{})[name] = parameterSchema
+		if parameter["required"] == true {
+			schema["required"] = append(schema["required"].([]interface{}), name)
+		}
+	}
+
+	requestBody := doc.resolve(operation["requestBody"])
+	content, _ := requestBody["content"].(map[string]interface{})
+	if len(content) > 0 {
+		if schema, exists := doc.resolve(content[preferredMediaType(content)])["schema"]; exists {
+			schemas["body"] = schema
+		}
+	}
+	return schemas, requestBody["required"] == true
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOpenapiSpec = `
+openapi: 3.0.3
+info: {title: pets, version: "1"}
+paths:
+  /pets/{petId}:
+    get:
+      operationId: getPet
+      parameters:
+        - {name: petId, in: path, required: true, schema: {type: integer, minimum: 1}}
+      responses:
+        "200":
+          description: a pet
+          content:
+            application/json:
+              example: {id: 1, name: rex}
+  /pets/{id}/toys:
+    get:
+      operationId: listToys
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+        - {name: limit, in: query, schema: {type: integer, maximum: 10}}
+      responses:
+        "200":
+          description: toys
+          content:
+            application/json:
+              schema:
+                type: array
+                items: {$ref: "#/components/schemas/Toy"}
+  /pets:
+    post:
+      operationId: createPet
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name: {type: string}
+      responses:
+        "201": {description: created}
+components:
+  schemas:
+    Toy:
+      type: object
+      properties:
+        name: {type: string}
+`
+
+func TestOpenapiImport(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"api.yaml": testOpenapiSpec})
+	existing := []EndpointStruct{{Url: "/v1/pets", Method: Methods{http.MethodPost}}}
+	endpoints, err := importOpenapi(filepath.Join(dir, "api.yaml"), "/v1", false, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []string{}
+	for _, endpoint := range endpoints {
+		routes = append(routes, endpoint.Method[0]+" "+endpoint.Url+" "+endpoint.Name)
+	}
+	// the params at the same position share the first name, the operation
+	// defined by the config is skipped
+	want := []string{"GET /v1/pets/:id/toys listToys", "GET /v1/pets/:id getPet"}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("routes %q, want %q", routes, want)
+	}
+}
+
+func TestOpenapiServesAndValidates(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"config.yaml": `
+server:
+  openapi:
+    - spec: api.yaml
+      prefix: /v1
+      validate: true
+`,
+		"api.yaml": testOpenapiSpec,
+	})
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		status int
+		errors []string
+	}{
+		{http.MethodGet, "/v1/pets/5", "", http.StatusOK, nil},
+		{http.MethodGet, "/v1/pets/0", "", http.StatusBadRequest, []string{"params.petId: must be at least 1"}},
+		{http.MethodGet, "/v1/pets/5/toys?limit=3", "", http.StatusOK, nil},
+		{http.MethodGet, "/v1/pets/x/toys?limit=11", "", http.StatusBadRequest, []string{
+			"params.id: expected integer, got string",
+			"query.limit: must be at most 10",
+		}},
+		{http.MethodPost, "/v1/pets", `{"name": "rex"}`, http.StatusCreated, nil},
+		{http.MethodPost, "/v1/pets", `{}`, http.StatusBadRequest, []string{"body: missing required property 'name'"}},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			status, body := serveTest(server, test.method, test.url, test.body, map[string]string{"Content-Type": "application/json"})
+			if status != test.status {
+				t.Fatalf("got %d %s, want %d", status, body, test.status)
+			}
+			if test.errors != nil {
+				failure := &ActionError{}
+				if err := json.Unmarshal([]byte(body), failure); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(failure.Errors, test.errors) {
+					t.Errorf("errors %q, want %q", failure.Errors, test.errors)
+				}
+			}
+		})
+	}
+
+	if _, body := serveTest(server, http.MethodGet, "/v1/pets/1", "", nil); !strings.Contains(body, `"rex"`) {
+		t.Errorf("body %s does not contain the example", body)
+	}
+}
+
+func TestOpenapiParamNames(t *testing.T) {
+	tests := []struct {
+		openapiUrl string
+		route      string
+		want       map[string]string
+	}{
+		{"/pets/{id}/toys", "/v1/pets/:petId/toys", map[string]string{"petId": "id"}},
+		{"/pets/{petId}", "/pets/:petId", map[string]string{"petId": "petId"}},
+		{"/a/{x}/b/{y}", "/hand/:first/b/:second", map[string]string{"first": "x", "second": "y"}},
+		{"/pets", "/pets", map[string]string{}},
+	}
+	for _, test := range tests {
+		if got := openapiParamNames(test.openapiUrl, test.route); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("openapiParamNames(%s, %s) = %v, want %v", test.openapiUrl, test.route, got, test.want)
+		}
+	}
+}
+
+const testOpenapiStaticSpec = `
+openapi: 3.0.3
+info: {title: users, version: "1"}
+paths:
+  /users/{id}:
+    get:
+      responses:
+        "200": {description: a user, content: {text/plain: {example: some user}}}
+  /users/me:
+    get:
+      responses:
+        "200": {description: the current user, content: {text/plain: {example: me}}}
+  /users/me/settings:
+    get:
+      responses:
+        "200": {description: settings, content: {text/plain: {example: settings}}}
+`
+
+func TestOpenapiStaticNextToParams(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"config.yaml": `
+server:
+  openapi:
+    - spec: api.yaml
+      validate: true
+`,
+		"api.yaml": testOpenapiStaticSpec,
+	})
+
+	// static routes are variants of the param route at their position
+	for url, want := range map[string]string{
+		"/users/7":           "some user",
+		"/users/me":          "me",
+		"/users/me/settings": "settings",
+	} {
+		if status, body := serveTest(server, http.MethodGet, url, "", nil); status != http.StatusOK || body != want {
+			t.Errorf("GET %s: got %d %s, want %s", url, status, body, want)
+		}
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/users/7/settings", "", nil); status != http.StatusNotFound {
+		t.Errorf("GET /users/7/settings: got %d, want 404", status)
+	}
+}
+
+func TestOpenapiConflictWithConfig(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"api.yaml": testOpenapiStaticSpec})
+	existing := []EndpointStruct{{Url: "/users/current", Method: Methods{http.MethodGet}}}
+	_, err := importOpenapi(filepath.Join(dir, "api.yaml"), "", false, existing)
+	if err == nil || !strings.Contains(err.Error(), "[GET] /users/{id} cannot be served") {
+		t.Errorf("got error %v, want a conflict of /users/{id}", err)
+	}
+}
+
+func TestOpenapiImportRejectsInvalidPatterns(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"api.yaml": `
+openapi: 3.0.3
+paths:
+  /items/{id}:
+    get:
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: string, pattern: "[0-"}}
+      responses:
+        "200": {description: ok}
+`})
+	// patterns are only used to validate requests
+	if _, err := importOpenapi(filepath.Join(dir, "api.yaml"), "", false, nil); err != nil {
+		t.Errorf("got error %v without validation", err)
+	}
+	_, err := importOpenapi(filepath.Join(dir, "api.yaml"), "", true, nil)
+	if err == nil || !strings.Contains(err.Error(), "[GET] /items/{id}: params.id: invalid pattern") {
+		t.Errorf("got error %v, want an invalid pattern", err)
+	}
+}
+
+func TestOpenapiFakeValue(t *testing.T) {
+	validator := parseTestSchema(t, `
+components:
+  schemas:
+    Base:
+      type: object
+      properties:
+        id: {type: integer, minimum: 3.5}
+    Pet:
+      allOf:
+        - $ref: "#/components/schemas/Base"
+        - {required: [name]}
+        - {type: object, properties: {name: {type: string}}}
+    Code:
+      allOf:
+        - {required: [code]}
+        - {type: string, format: uuid}
+        - {type: object, properties: {ignored: {type: string}}}
+    Tags:
+      type: array
+      minItems: 2.0
+      items: {type: string}
+`)
+	doc := validator.doc
+	tests := []struct {
+		schema string
+		want   interface{}
+	}{
+		{"Pet", map[string]interface{}{"id": 4, "name": "name"}},
+		{"Code", "3fa85f64-5717-4562-b3fc-2c963f66afa6"},
+		{"Tags", []interface{}{"string", "string"}},
+	}
+	for _, test := range tests {
+		schema := map[string]interface{}{"$ref": "#/components/schemas/" + test.schema}
+		if got := doc.fakeValue(schema, "", map[string]bool{}); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.schema, got, test.want)
+		}
+	}
+}
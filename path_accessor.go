@@ -42,3 +42,19 @@ func (pathAccessor *PathAccessor) Must(path string) (interface{}, error) {
 	}
 	return getRecursive(pathAccessor.config, strings.Split(path, "."))
 }
+
+// Set stores value at path, creating missing intermediate maps. Existing
+// non-map values along the path are replaced.
+func (pathAccessor *PathAccessor) Set(path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := pathAccessor.config
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
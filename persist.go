@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CacheConfig configures the persistence of the global context and the file
+// cache, which are shared by all servers.
+type CacheConfig struct {
+	// directory holding the cache snapshots and cached files, empty keeps the
+	// caches in memory only
+	Directory string
+	// file with the initial cache contents, loaded on startup unless a
+	// snapshot exists
+	Fixture string
+}
+
+// CacheFixture is the format of the fixture file.
+type CacheFixture struct {
+	Global map[string]any
+	Files  map[string]struct {
+		LocalFile string `yaml:"localFile"`
+		Headers   map[string][]string
+	}
+}
+
+// delay between a change and writing the snapshot, so that bursts of changes
+// are written at once
+const cacheSnapshotDelay = 100 * time.Millisecond
+
+// persistentCache is an in-memory cache writing a JSON snapshot of its
+// entries to path whenever it changes.
+type persistentCache struct {
+	*cacheImpl
+	path string
+	// conversion of values from and to their snapshot representation
+	encode func(value any) (any, error)
+	decode func(data json.RawMessage) (any, error)
+
+	saveLock  sync.Mutex
+	saveTimer *time.Timer
+	closed    bool
+}
+
+type cacheSnapshotEntry struct {
+	Value   json.RawMessage `json:"value"`
+	Expires *time.Time      `json:"expires,omitempty"`
+}
+
+func newPersistentCache(
+	path string,
+	additionalRemovalAction func(key string, value any),
+	encode func(value any) (any, error),
+	decode func(data json.RawMessage) (any, error),
+) *persistentCache {
+	cache := &persistentCache{path: path, encode: encode, decode: decode}
+	cache.cacheImpl = NewCache(func(key string, value any) {
+		additionalRemovalAction(key, value)
+		cache.scheduleSave()
+	}).(*cacheImpl)
+	return cache
+}
+
+func (cache *persistentCache) Add(key string, value any, timeout time.Duration) {
+	cache.cacheImpl.Add(key, value, timeout)
+	cache.scheduleSave()
+}
+
+func (cache *persistentCache) Update(key string, timeout time.Duration, update func(value any, exists bool) (any, bool)) bool {
+	updated := cache.cacheImpl.Update(key, timeout, update)
+	if updated {
+		cache.scheduleSave()
+	}
+	return updated
+}
+
+func (cache *persistentCache) Clear() {
+	cache.cacheImpl.Clear()
+	cache.scheduleSave()
+}
+
+// Close writes the final snapshot. Entries are kept, so that they are
+// available again after a restart.
+func (cache *persistentCache) Close() error {
+	cache.saveLock.Lock()
+	defer cache.saveLock.Unlock()
+	if cache.saveTimer != nil {
+		cache.saveTimer.Stop()
+	}
+	cache.closed = true
+	return cache.save()
+}
+
+func (cache *persistentCache) scheduleSave() {
+	cache.saveLock.Lock()
+	defer cache.saveLock.Unlock()
+	if cache.closed || cache.saveTimer != nil {
+		return
+	}
+	cache.saveTimer = time.AfterFunc(cacheSnapshotDelay, func() {
+		cache.saveLock.Lock()
+		defer cache.saveLock.Unlock()
+		cache.saveTimer = nil
+		if cache.closed {
+			return
+		}
+		if err := cache.save(); err != nil {
+			log.Printf(">> ERROR << failed to write cache snapshot: %v", err)
+		}
+	})
+}
+
+// save writes the snapshot, the caller must hold saveLock.
+func (cache *persistentCache) save() error {
+	snapshot := make(map[string]cacheSnapshotEntry)
+	for key, entry := range cache.Entries() {
+		value, err := cache.encode(entry.Value)
+		if err != nil {
+			return fmt.Errorf("cache entry '%s': %w", key, err)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("cache entry '%s': %w", key, err)
+		}
+		snapshotEntry := cacheSnapshotEntry{Value: data}
+		if expires := entry.Expires; !expires.IsZero() {
+			snapshotEntry.Expires = &expires
+		}
+		snapshot[key] = snapshotEntry
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	// replace atomically, so that a crash never leaves a partial snapshot
+	tmpPath := cache.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cache.path)
+}
+
+// load restores the entries of the snapshot, skipping expired ones. It
+// reports whether a snapshot exists.
+func (cache *persistentCache) load() (bool, error) {
+	data, err := os.ReadFile(cache.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	snapshot := make(map[string]cacheSnapshotEntry)
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return true, fmt.Errorf("%s: %w", cache.path, err)
+	}
+	for key, entry := range snapshot {
+		var timeout time.Duration
+		if entry.Expires != nil {
+			if timeout = time.Until(*entry.Expires); timeout <= 0 {
+				continue
+			}
+		}
+		value, err := cache.decode(entry.Value)
+		if err != nil {
+			log.Printf("| Skipping cache entry '%s' of %s: %v", key, cache.path, err)
+			continue
+		}
+		cache.cacheImpl.Add(key, value, timeout)
+	}
+	return true, nil
+}
+
+// setupCaches replaces the in-memory caches with persistent ones and seeds
+// them from the fixture, as configured.
+func setupCaches(cfg *CacheConfig) error {
+	var fixture *CacheFixture
+	if cfg.Fixture != "" {
+		data, err := os.ReadFile(cfg.Fixture)
+		if err != nil {
+			return err
+		}
+		fixture = &CacheFixture{}
+		if err := yaml.Unmarshal(data, fixture); err != nil {
+			return fmt.Errorf("%s: %w", cfg.Fixture, err)
+		}
+	}
+	if cfg.Directory == "" {
+		if fixture != nil {
+			return seedCaches(fixture, cfg.Fixture, true, true)
+		}
+		return nil
+	}
+
+	filesPath := filepath.Join(cfg.Directory, "files")
+	if err := os.MkdirAll(filesPath, 0700); err != nil {
+		return err
+	}
+	__cache_path__ = filesPath
+
+	global := newPersistentCache(
+		filepath.Join(cfg.Directory, "global.json"),
+		func(key string, value any) {},
+		func(value any) (any, error) { return value, nil },
+		decodeCacheValue)
+	files := newPersistentCache(
+		filepath.Join(cfg.Directory, "files.json"),
+		removeCacheFile,
+		encodeCacheFile,
+		decodeCacheFile)
+	globalLoaded, err := global.load()
+	if err != nil {
+		return err
+	}
+	filesLoaded, err := files.load()
+	if err != nil {
+		return err
+	}
+	removeOrphanedCacheFiles(filesPath, files)
+	log.Printf("| Persisting caches in %s", cfg.Directory)
+
+	globalContext, fileCache = global, files
+	if fixture != nil {
+		return seedCaches(fixture, cfg.Fixture, !globalLoaded, !filesLoaded)
+	}
+	return nil
+}
+
+// seedCaches adds the entries of fixture to the global context and/or the
+// file cache. They do not expire.
+func seedCaches(fixture *CacheFixture, fixturePath string, global bool, files bool) error {
+	if !global && !files {
+		return nil
+	}
+	if global {
+		for key, value := range fixture.Global {
+			globalContext.Add(key, value, 0)
+		}
+	}
+	if files {
+		for key, file := range fixture.Files {
+			localFile, err := os.Open(resolveConfigPath(fixturePath, file.LocalFile))
+			if err != nil {
+				return fmt.Errorf("fixture file '%s': %w", key, err)
+			}
+			cacheFile, err := NewCacheFileFromReader(localFile, file.Headers)
+			localFile.Close()
+			if err != nil {
+				return fmt.Errorf("fixture file '%s': %w", key, err)
+			}
+			fileCache.Add(key, cacheFile, 0)
+		}
+	}
+	log.Printf("| Seeded caches from %s", fixturePath)
+	return nil
+}
+
+// closeCaches releases the caches on shutdown.
+func closeCaches() {
+	for _, cache := range []Cache{globalContext, fileCache} {
+		if err := cache.Close(); err != nil {
+			log.Printf(">> ERROR << failed to close cache: %v", err)
+		}
+	}
+}
+
+func removeCacheFile(key string, value any) {
+	value.(CacheFile).Remove()
+}
+
+// cacheFileSnapshot is the snapshot representation of a cached file, the
+// file itself stays in the files directory.
+type cacheFileSnapshot struct {
+	File    string              `json:"file"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+func encodeCacheFile(value any) (any, error) {
+	cacheFile, ok := value.(*cacheFileImpl)
+	if !ok {
+		return nil, fmt.Errorf("unsupported cache file %T", value)
+	}
+	return cacheFileSnapshot{
+		File:    filepath.Base(cacheFile.tmpFile.Name()),
+		Headers: cacheFile.Headers,
+	}, nil
+}
+
+// decodeCacheValue decodes a value of the global context. Whole numbers are
+// restored as int, like the values stored by the actions.
+func decodeCacheValue(data json.RawMessage) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return restoreCacheNumbers(value), nil
+}
+
+func restoreCacheNumbers(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(value.String()); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		for key, item := range value {
+			value[key] = restoreCacheNumbers(item)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = restoreCacheNumbers(item)
+		}
+	}
+	return value
+}
+
+func decodeCacheFile(data json.RawMessage) (any, error) {
+	snapshot := cacheFileSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(__cache_path__, filepath.Base(snapshot.File)))
+	if err != nil {
+		return nil, err
+	}
+	return &cacheFileImpl{tmpFile: file, Headers: snapshot.Headers}, nil
+}
+
+// removeOrphanedCacheFiles removes files not referenced by the snapshot,
+// e.g. left behind by a crash.
+func removeOrphanedCacheFiles(directory string, files Cache) {
+	referenced := make(map[string]bool)
+	for _, entry := range files.Entries() {
+		referenced[filepath.Base(entry.Value.(*cacheFileImpl).tmpFile.Name())] = true
+	}
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return
+	}
+	for _, dirEntry := range dirEntries {
+		if name := dirEntry.Name(); strings.HasPrefix(name, "dummyserver_cachefile_") && !referenced[name] {
+			os.Remove(filepath.Join(directory, name))
+		}
+	}
+}
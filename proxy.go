@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	proxyModeRecord = "record"
+	proxyModeReplay = "replay"
+)
+
+// Headers that are not forwarded to or recorded from the upstream.
+var proxyIgnoredHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	// let the http client negotiate and decode compression, so that bodies
+	// are recorded in plain text
+	"Accept-Encoding", "Content-Encoding", "Content-Length", "Date",
+}
+
+// recordingsLock serializes all writes to recording files.
+var recordingsLock = &sync.Mutex{}
+
+// recordingProxy forwards requests to an upstream server and records each
+// request/response pair as endpoint definition.
+type recordingProxy struct {
+	upstream      *url.URL
+	recordings    string
+	bodyDirectory string
+	// request headers recorded as match predicates
+	matchHeaders []string
+}
+
+func newRecordingProxy(upstream string, recordings string, bodyDirectory string, matchHeaders []string) (*recordingProxy, error) {
+	upstreamUrl, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy upstream '%s': %w", upstream, err)
+	}
+	if upstreamUrl.Scheme == "" || upstreamUrl.Host == "" {
+		return nil, fmt.Errorf("invalid proxy upstream '%s': scheme and host required", upstream)
+	}
+	return &recordingProxy{upstreamUrl, recordings, bodyDirectory, matchHeaders}, nil
+}
+
+func (proxy *recordingProxy) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	requestId := "proxy"
+	if entry := journalEntryOf(request); entry != nil {
+		requestId = entry.Id
+		entry.Endpoint = "proxy " + proxy.upstream.String()
+	}
+	log.Printf("[%s] %s %s [size=%d] -> %s", requestId, request.Method, request.RequestURI, request.ContentLength, proxy.upstream)
+
+	// the body is forwarded and recorded as match predicate
+	requestBody, err := io.ReadAll(request.Body)
+	if err != nil {
+		log.Printf("[%s] >> PROXY ERROR << %v", requestId, err)
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target := *proxy.upstream
+	target.Path = strings.TrimSuffix(target.Path, "/") + request.URL.Path
+	target.RawPath = ""
+	target.RawQuery = request.URL.RawQuery
+	upstreamRequest, err := http.NewRequestWithContext(request.Context(), request.Method, target.String(), bytes.NewReader(requestBody))
+	if err != nil {
+		log.Printf("[%s] >> PROXY ERROR << %v", requestId, err)
+		http.Error(response, err.Error(), http.StatusBadGateway)
+		return
+	}
+	upstreamRequest.Header = request.Header.Clone()
+	for _, header := range proxyIgnoredHeaders {
+		upstreamRequest.Header.Del(header)
+	}
+
+	result, err := performRequest(requestId, upstreamRequest)
+	if err != nil {
+		log.Printf("[%s] >> PROXY ERROR << %v", requestId, err)
+		http.Error(response, err.Error(), http.StatusBadGateway)
+		return
+	}
+	status := result["status"].(int)
+	headers := result["headers"].(http.Header)
+	body := result["body"].(string)
+	for _, header := range proxyIgnoredHeaders {
+		headers.Del(header)
+	}
+
+	for key, values := range headers {
+		response.Header()[key] = values
+	}
+	response.WriteHeader(status)
+	response.Write([]byte(body))
+
+	if err := proxy.record(request, requestBody, status, headers, body); err != nil {
+		log.Printf("[%s] >> RECORDING ERROR << %v", requestId, err)
+	}
+}
+
+// record appends an endpoint replaying the given response to the recordings
+// file, unless an endpoint with the same method, path and match predicates was
+// recorded already.
+func (proxy *recordingProxy) record(request *http.Request, requestBody []byte, status int, headers http.Header, body string) error {
+	path := request.URL.Path
+	if strings.ContainsAny(path, ":*") {
+		return fmt.Errorf("cannot record %s, path contains router wildcards", path)
+	}
+
+	recordingsLock.Lock()
+	defer recordingsLock.Unlock()
+
+	recordings := &Config{}
+	if bytes, err := os.ReadFile(proxy.recordings); err == nil {
+		if err := yaml.Unmarshal(bytes, recordings); err != nil {
+			return fmt.Errorf("%s: %w", proxy.recordings, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// requests differing in their query, body or match headers are recorded
+	// as separate variants
+	match := proxy.recordMatch(request, requestBody)
+	for _, endpoint := range recordings.Endpoints {
+		if endpoint.Method.String() == request.Method && endpoint.Url == path && reflect.DeepEqual(endpoint.Match, match) {
+			return nil
+		}
+	}
+
+	responseParams := map[string]interface{}{"status": status}
+	if len(headers) > 0 {
+		headerList := make([]interface{}, 0, len(headers))
+		for key, values := range headers {
+			// headers like Set-Cookie cannot be joined into one value
+			if len(values) == 1 {
+				headerList = append(headerList, map[string]interface{}{key: escapeTemplate(values[0])})
+				continue
+			}
+			valueList := make([]interface{}, 0, len(values))
+			for _, value := range values {
+				valueList = append(valueList, escapeTemplate(value))
+			}
+			headerList = append(headerList, map[string]interface{}{key: valueList})
+		}
+		responseParams["headers"] = headerList
+	}
+	if proxy.bodyDirectory != "" && body != "" {
+		bodyFile, err := proxy.writeBodyFile(request.Method, path, headers.Get("Content-Type"), body)
+		if err != nil {
+			return err
+		}
+		responseParams["localFile"] = bodyFile
+	} else {
+		responseParams["body"] = escapeTemplate(body)
+	}
+
+	recordings.Endpoints = append(recordings.Endpoints, EndpointStruct{
+		Url:     path,
+		Method:  Methods{request.Method},
+		Match:   match,
+		Actions: []ActionStruct{{Type: "response", Params: responseParams}},
+	})
+	bytes, err := yaml.Marshal(map[string]interface{}{"endpoints": recordings.Endpoints})
+	if err != nil {
+		return err
+	}
+	log.Printf("| Recorded [%s] %s to %s", request.Method, path, proxy.recordings)
+	return os.WriteFile(proxy.recordings, bytes, 0644)
+}
+
+// recordMatch returns the match predicates replaying request, or nil if the
+// request has no query, body or match headers.
+func (proxy *recordingProxy) recordMatch(request *http.Request, requestBody []byte) *MatchStruct {
+	match := &MatchStruct{}
+	if query := request.URL.Query(); len(query) > 0 {
+		match.Query = make(map[string]Matcher, len(query))
+		for key, values := range query {
+			match.Query[key] = Matcher{Equals: values[0]}
+		}
+	}
+	for _, header := range proxy.matchHeaders {
+		if value := request.Header.Get(header); value != "" {
+			if match.Headers == nil {
+				match.Headers = make(map[string]Matcher)
+			}
+			match.Headers[http.CanonicalHeaderKey(header)] = Matcher{Equals: value}
+		}
+	}
+	if len(requestBody) > 0 {
+		match.Body = map[string]Matcher{"$": {Equals: string(requestBody)}}
+	}
+	if match.specificity() == 0 {
+		return nil
+	}
+	return match
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// writeBodyFile stores body in a new file of the body directory and returns
+// its path.
+func (proxy *recordingProxy) writeBodyFile(method string, path string, contentType string, body string) (string, error) {
+	if err := os.MkdirAll(proxy.bodyDirectory, 0755); err != nil {
+		return "", err
+	}
+	extension := ".body"
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			extension = extensions[0]
+		}
+	}
+	name := method + unsafeFileNameChars.ReplaceAllString(path, "_")
+	fileName := filepath.Join(proxy.bodyDirectory, name+extension)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(fileName); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		fileName = filepath.Join(proxy.bodyDirectory, fmt.Sprintf("%s_%d%s", name, i, extension))
+	}
+	return fileName, os.WriteFile(fileName, []byte(body), 0644)
+}
+
+// escapeTemplate escapes all template actions in text, so that the template
+// engine reproduces text verbatim.
+func escapeTemplate(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestProxyRecordAndReplay(t *testing.T) {
+	upstreamRequests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		upstreamRequests++
+		body, _ := io.ReadAll(request.Body)
+		response.Header().Set("X-Upstream", "yes")
+		response.Header().Add("Set-Cookie", "a=1")
+		response.Header().Add("Set-Cookie", "b=2, 3; Path=/")
+		response.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(response, "%s %s tenant=%s body=%s {{.x}}", request.Method, request.URL, request.Header.Get("X-Tenant"), body)
+	}))
+	defer upstream.Close()
+
+	config := `
+endpoints:
+  - url: /configured
+    method: GET
+    actions:
+      - type: response
+        params: {body: configured}
+`
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+server:
+  proxy:
+    mode: record
+    upstream: ` + upstream.URL + `
+    matchHeaders: [x-tenant]
+` + config})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := newDummyServer(&cfg.Servers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method  string
+		url     string
+		body    string
+		headers map[string]string
+		want    string
+	}{
+		{http.MethodGet, "/users?id=1", "", nil, "GET /users?id=1 tenant= body= {{.x}}"},
+		{http.MethodGet, "/users?id=2", "", nil, "GET /users?id=2 tenant= body= {{.x}}"},
+		{http.MethodPost, "/users", `{"name": "a"}`, nil, `POST /users tenant= body={"name": "a"} {{.x}}`},
+		{http.MethodPost, "/users", `{"name": "b"}`, nil, `POST /users tenant= body={"name": "b"} {{.x}}`},
+		{http.MethodPost, "/users", `{"name": "a"}`, map[string]string{"X-Tenant": "t1"}, `POST /users tenant=t1 body={"name": "a"} {{.x}}`},
+	}
+	for _, request := range requests {
+		// repeated requests are proxied again, but recorded once
+		for i := 0; i < 2; i++ {
+			if status, body := serveTest(recorder, request.method, request.url, request.body, request.headers); status != http.StatusCreated || body != request.want {
+				t.Errorf("record %s %s: got %d %q, want %q", request.method, request.url, status, body, request.want)
+			}
+		}
+	}
+	if _, body := serveTest(recorder, http.MethodGet, "/configured", "", nil); body != "configured" {
+		t.Errorf("configured endpoint answers %q while recording", body)
+	}
+	if upstreamRequests != 2*len(requests) {
+		t.Errorf("upstream got %d requests, want %d", upstreamRequests, 2*len(requests))
+	}
+
+	recordings, err := os.ReadFile(filepath.Join(dir, "recordings.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := &Config{}
+	if err := yaml.Unmarshal(recordings, recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.Endpoints) != len(requests) {
+		t.Errorf("got %d recordings, want %d:\n%s", len(recorded.Endpoints), len(requests), recordings)
+	}
+
+	replayer := newTestServer(t, map[string]string{
+		"recordings.yaml": string(recordings),
+		"config.yaml": `
+server:
+  proxy:
+    mode: replay
+` + config,
+	})
+	for _, request := range requests {
+		status, body := serveTest(replayer, request.method, request.url, request.body, request.headers)
+		if status != http.StatusCreated || body != request.want {
+			t.Errorf("replay %s %s: got %d %q, want %q", request.method, request.url, status, body, request.want)
+		}
+	}
+	// only the recordings are served
+	if status, body := serveTest(replayer, http.MethodGet, "/configured", "", nil); status != http.StatusNotFound {
+		t.Errorf("configured endpoint answers %d %q while replaying, want 404", status, body)
+	}
+	// headers with several values are replayed value by value
+	replayed := httptest.NewRecorder()
+	replayer.ServeHTTP(replayed, httptest.NewRequest(http.MethodGet, "/users?id=1", nil))
+	if cookies := replayed.Header().Values("Set-Cookie"); !reflect.DeepEqual(cookies, []string{"a=1", "b=2, 3; Path=/"}) {
+		t.Errorf("replayed Set-Cookie %q", cookies)
+	}
+	if upstreamHeader := replayed.Header().Get("X-Upstream"); upstreamHeader != "yes" {
+		t.Errorf("replayed X-Upstream %q", upstreamHeader)
+	}
+	if status, _ := serveTest(replayer, http.MethodPost, "/users", `{"name": "c"}`, nil); status != http.StatusNotFound {
+		t.Errorf("unrecorded body: got %d, want 404", status)
+	}
+	if upstreamRequests != 2*len(requests) {
+		t.Error("replay mode contacted the upstream")
+	}
+}
+
+func TestProxyUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	server := newTestServer(t, map[string]string{"config.yaml": `
+server:
+  proxy:
+    mode: record
+    upstream: ` + upstream.URL + `
+`})
+	if status, _ := serveTest(server, http.MethodGet, "/down", "", nil); status != http.StatusBadGateway {
+		t.Errorf("got %d, want 502", status)
+	}
+}
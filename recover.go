@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ActionError is the failure of an action while handling a request. Actions
+// report it through actionPanic, other panics are converted to it.
+type ActionError struct {
+	RequestId string `json:"requestId"`
+	Action    string `json:"action"`
+	Message   string `json:"message"`
+	// Optional details, e.g. the violations found by the validate action
+	Errors []string `json:"errors,omitempty"`
+	// Optional status of the error response, overriding the server's
+	Status int `json:"-"`
+}
+
+func (err *ActionError) Error() string {
+	return fmt.Sprintf("action %s: %s", err.Action, err.Message)
+}
+
+// actionFailureOf converts the recovered value r of a failed action into an
+// ActionError.
+func actionFailureOf(r any, requestId string, endpoint EndpointStruct, action string) *ActionError {
+	if failure, ok := r.(*ActionError); ok {
+		return failure
+	}
+	failure := &ActionError{RequestId: requestId, Action: action, Message: fmt.Sprint(r)}
+	log.Printf("[%s] >> ERROR << [%s|%s] action:%s\n%s\n%s",
+		requestId,
+		endpoint.Method,
+		endpoint.Url,
+		action,
+		failure.Message,
+		debug.Stack())
+	return failure
+}
+
+// handleActionFailure answers a request whose actions failed, either with the
+// onError actions of the endpoint or with a JSON error response.
+func handleActionFailure(
+	failure *ActionError,
+	status int,
+	errorHandlers []ActionHandler,
+	response http.ResponseWriter,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) {
+	if entry := journalEntryOf(request); entry != nil {
+		entry.Error = failure.Error()
+	}
+	if responseStarted(response) {
+		log.Printf("[%s] response already started, cannot send error response", failure.RequestId)
+		return
+	}
+	discardResponse(response)
+	if failure.Status != 0 {
+		status = failure.Status
+	} else if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	if len(errorHandlers) > 0 {
+		context["__error__"] = map[string]interface{}{
+			"requestId": failure.RequestId,
+			"action":    failure.Action,
+			"message":   failure.Message,
+			"errors":    failure.Errors,
+			"status":    status,
+		}
+		if runErrorHandlers(failure.RequestId, errorHandlers, response, request, params, context) ||
+			responseStarted(response) {
+			return
+		}
+		discardResponse(response)
+	}
+
+	body, _ := json.Marshal(failure)
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	response.Write(body)
+}
+
+// runErrorHandlers runs the onError actions of an endpoint and reports whether
+// all of them succeeded.
+func runErrorHandlers(
+	requestId string,
+	errorHandlers []ActionHandler,
+	response http.ResponseWriter,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			log.Printf("[%s] >> ERROR << onError actions failed: %v", requestId, r)
+		}
+	}()
+	for _, handler := range errorHandlers {
+		handler(requestId, response, request, params, context)
+	}
+	return true
+}
+
+// responseStarted reports whether the status of response has already been
+// sent, so that no error response can be written anymore. Wrapped response
+// writers are unwrapped until one knows whether it has started.
+func responseStarted(response http.ResponseWriter) bool {
+	for {
+		switch writer := response.(type) {
+		case interface{ Started() bool }:
+			return writer.Started()
+		case interface{ Unwrap() http.ResponseWriter }:
+			response = writer.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// discardResponse drops the buffered parts of a response which has not been
+// started, so that the error response replaces them.
+func discardResponse(response http.ResponseWriter) {
+	for {
+		switch writer := response.(type) {
+		case interface{ discard() }:
+			writer.discard()
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			response = writer.Unwrap()
+		default:
+			return
+		}
+	}
+}
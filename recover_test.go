@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestOnErrorChain(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+server:
+  errorStatus: 502
+endpoints:
+  - url: /handled
+    method: GET
+    actions:
+      - type: response
+        params: {localFile: ./missing.json}
+    onError:
+      - type: response
+        params:
+          status: 404
+          body: '{{.__error__.action}} failed with {{.__error__.status}}'
+  - url: /unhandled
+    method: GET
+    actions:
+      - type: response
+        params: {localFile: ./missing.json}
+  - url: /failing-handler
+    method: GET
+    actions:
+      - type: response
+        params: {localFile: ./missing.json}
+    onError:
+      - type: response
+        params: {localFile: ./also-missing.json}
+`})
+
+	if status, body := serveTest(server, http.MethodGet, "/handled", "", nil); status != http.StatusNotFound || body != "response failed with 502" {
+		t.Errorf("handled: got %d %q", status, body)
+	}
+	for _, url := range []string{"/unhandled", "/failing-handler"} {
+		// without a successful onError chain the failure is answered as JSON
+		status, body := serveTest(server, http.MethodGet, url, "", nil)
+		failure := ActionError{}
+		if err := json.Unmarshal([]byte(body), &failure); err != nil || status != http.StatusBadGateway ||
+			failure.Action != "response" || failure.RequestId == "" {
+			t.Errorf("%s: got %d %s", url, status, body)
+		}
+	}
+
+	_, body := serveTest(server, http.MethodGet, "/__admin/requests", "", nil)
+	entries := []struct{ Error string }{}
+	if err := json.Unmarshal([]byte(body), &entries); err != nil || len(entries) != 3 {
+		t.Fatalf("unexpected journal %s: %v", body, err)
+	}
+	for _, entry := range entries {
+		if entry.Error == "" {
+			t.Errorf("failure missing in the journal: %s", body)
+		}
+	}
+}
+
+func TestOnErrorReplacesBufferedResponse(t *testing.T) {
+	// the bandwidth fault buffers the response until the actions are done
+	server := newTestServer(t, map[string]string{"config.yaml": `
+server:
+  fault:
+    bandwidth: 1000000
+endpoints:
+  - url: /late-failure
+    method: GET
+    actions:
+      - type: response
+        params: {status: 201, body: partial}
+      - type: cache-get
+        params: {mapping: {missing-key: result}}
+    onError:
+      - type: response
+        params: {status: 404, body: 'recovered from {{.__error__.action}}'}
+`})
+	if status, body := serveTest(server, http.MethodGet, "/late-failure", "", nil); status != http.StatusNotFound || body != "recovered from cache-get" {
+		t.Errorf("got %d %q, want 404 recovered from cache-get", status, body)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// routerSwitch serves requests with the most recently stored handler, which
+// allows replacing the complete router of a running server atomically.
+type routerSwitch struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (rs *routerSwitch) Store(handler http.Handler) {
+	rs.handler.Store(&handler)
+}
+
+func (rs *routerSwitch) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	(*rs.handler.Load()).ServeHTTP(response, request)
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		if finfo, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{finfo.ModTime(), finfo.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, stamp := range a {
+		if other, exists := b[file]; !exists || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+// watchConfig polls the config file and all of its includes for changes and
+// swaps the routers of all servers once a changed config was built
+// successfully. A broken config is logged together with its diff to the last
+// good config and otherwise ignored, i.e. the last good config continues to
+// be served.
+func watchConfig(configFile string, active *Config, servers []*dummyServer, interval time.Duration) {
+	stamps := statFiles(active.watchedFiles())
+	for range time.Tick(interval) {
+		current := statFiles(active.watchedFiles())
+		if stampsEqual(stamps, current) {
+			continue
+		}
+		stamps = current
+		log.Printf("| Config change detected, reloading %s", configFile)
+
+		cfg, err := loadConfig(configFile)
+		if err == nil {
+			if err = reloadServers(servers, cfg); err == nil {
+				active = cfg
+				stamps = statFiles(active.watchedFiles())
+				log.Printf("| Config reloaded")
+				continue
+			}
+			log.Printf("| >> RELOAD ERROR << %v\n%s", err, lineDiff(active.source, cfg.source))
+		} else {
+			// the files of the last good config, as far as a broken one is known
+			log.Printf("| >> RELOAD ERROR << %v\n%s", err, lineDiff(active.source, readConfigSource(active.sourceFiles)))
+		}
+		log.Printf("| Keeping last good config")
+	}
+}
+
+// reloadServers replaces the config of all running servers, keeping their
+// runtime endpoints. The routers of all servers are built before any of them
+// is swapped, so either all servers or none are updated. Servers are matched
+// by name; adding or removing servers requires a restart.
+func reloadServers(servers []*dummyServer, cfg *Config) error {
+	configs := make(map[string]*ServerConfig, len(cfg.Servers))
+	for i := range cfg.Servers {
+		configs[cfg.Servers[i].Name] = &cfg.Servers[i]
+	}
+	for _, server := range servers {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+	}
+
+	handlers := make([]http.Handler, len(servers))
+	services := make([]*grpcServices, len(servers))
+	newConfigs := make([]*ServerConfig, len(servers))
+	for i, server := range servers {
+		active := server.config
+		newConfig, exists := configs[server.name]
+		if !exists {
+			log.Printf("| Removing server %s requires a restart, keeping its config", server.name)
+			newConfig = active
+		}
+		delete(configs, server.name)
+		if newConfig.Ip != active.Ip || newConfig.Port != active.Port {
+			log.Printf("| Server address changes require a restart, still serving %s on %s:%d",
+				server.name, active.Ip, active.Port)
+			newConfig.Ip, newConfig.Port = active.Ip, active.Port
+		}
+		if !reflect.DeepEqual(newConfig.Tls, active.Tls) {
+			log.Printf("| TLS changes require a restart, keeping the current certificates of %s", server.name)
+			newConfig.Tls = active.Tls
+		}
+		if (newConfig.Grpc == nil) != (active.Grpc == nil) {
+			log.Printf("| Adding or removing the gRPC listener requires a restart, keeping it as is for %s", server.name)
+			newConfig.Grpc = active.Grpc
+		} else if newConfig.Grpc != nil && newConfig.Grpc.Port != active.Grpc.Port {
+			log.Printf("| gRPC port changes require a restart, still serving %s on port %d", server.name, active.Grpc.Port)
+			newConfig.Grpc.Port = active.Grpc.Port
+		}
+		if newConfig.Cache != active.Cache {
+			log.Printf("| Cache persistence changes require a restart, ignoring them for %s", server.name)
+			newConfig.Cache = active.Cache
+		}
+		var err error
+		if handlers[i], services[i], err = server.build(newConfig, server.runtimeEndpoints); err != nil {
+			return fmt.Errorf("server %s: %w", server.name, err)
+		}
+		newConfigs[i] = newConfig
+	}
+	for name := range configs {
+		log.Printf("| Adding server %s requires a restart", name)
+	}
+
+	for i, server := range servers {
+		server.commit(newConfigs[i], server.runtimeEndpoints, handlers[i], services[i])
+	}
+	return nil
+}
+
+// lineDiff returns the changed lines between a and b, prefixed with '-' for
+// removed and '+' for added lines.
+func lineDiff(a, b string) string {
+	linesA := strings.Split(a, "\n")
+	linesB := strings.Split(b, "\n")
+
+	// longest common subsequence table
+	lcs := make([][]int, len(linesA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := &strings.Builder{}
+	i, j := 0, 0
+	for i < len(linesA) || j < len(linesB) {
+		switch {
+		case i < len(linesA) && j < len(linesB) && linesA[i] == linesB[j]:
+			i++
+			j++
+		case i < len(linesA) && (j == len(linesB) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(diff, "%4d - %s\n", i+1, linesA[i])
+			i++
+		default:
+			fmt.Fprintf(diff, "%4d + %s\n", j+1, linesB[j])
+			j++
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+)
+
+// Every scenario starts in this state and returns to it when reset.
+const scenarioStarted = "started"
+
+// ScenarioMatch restricts an endpoint to a state of a scenario.
+type ScenarioMatch struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// scenarioStore keeps the current states of all scenarios, which are shared
+// by all servers. Scenarios are scoped by cache namespaces, keys are built
+// with namespacedKey.
+type scenarioStore struct {
+	lock   sync.RWMutex
+	states map[string]string
+}
+
+var scenarios = &scenarioStore{states: make(map[string]string)}
+
+func (store *scenarioStore) State(key string) string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if state, exists := store.states[key]; exists {
+		return state
+	}
+	return scenarioStarted
+}
+
+// Transition moves the scenario to state if its current state is from, or
+// unconditionally if from is empty. It reports whether the state was changed.
+func (store *scenarioStore) Transition(key string, from string, state string) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	current, exists := store.states[key]
+	if !exists {
+		current = scenarioStarted
+	}
+	if from != "" && current != from {
+		return false
+	}
+	if state == scenarioStarted {
+		delete(store.states, key)
+	} else {
+		store.states[key] = state
+	}
+	return true
+}
+
+// Reset returns the scenario to its initial state and reports whether it
+// was in another state.
+func (store *scenarioStore) Reset(key string) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	_, exists := store.states[key]
+	delete(store.states, key)
+	return exists
+}
+
+// ResetNamespace resets all scenarios of namespace.
+func (store *scenarioStore) ResetNamespace(namespace string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key := range store.states {
+		if _, ok := inNamespace(namespace, key); ok {
+			delete(store.states, key)
+		}
+	}
+}
+
+func (store *scenarioStore) ResetAll() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.states = make(map[string]string)
+}
+
+// States returns the scenarios not in their initial state.
+func (store *scenarioStore) States() map[string]string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	states := make(map[string]string, len(store.states))
+	for key, state := range store.states {
+		states[key] = state
+	}
+	return states
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestScenarioTransition(t *testing.T) {
+	store := &scenarioStore{states: make(map[string]string)}
+	if store.Transition("job", "running", "done") {
+		t.Error("transitioned from a state the scenario is not in")
+	}
+	if !store.Transition("job", scenarioStarted, "running") || !store.Transition("job", "running", "done") {
+		t.Error("transition failed")
+	}
+	if state := store.State("job"); state != "done" {
+		t.Errorf("state is %s, want done", state)
+	}
+	store.Transition("job", "", scenarioStarted)
+	if len(store.States()) != 0 {
+		t.Errorf("initial states are listed: %v", store.States())
+	}
+}
+
+func TestScenarioResetNamespace(t *testing.T) {
+	store := &scenarioStore{states: make(map[string]string)}
+	store.Transition(namespacedKey("a", "job"), "", "done")
+	store.Transition(namespacedKey("b", "job"), "", "done")
+	store.ResetNamespace("a")
+
+	if state := store.State(namespacedKey("a", "job")); state != scenarioStarted {
+		t.Errorf("scenario of namespace a is %s, want %s", state, scenarioStarted)
+	}
+	if state := store.State(namespacedKey("b", "job")); state != "done" {
+		t.Errorf("scenario of namespace b is %s, want done", state)
+	}
+}
+
+func TestAdminScenarioNamespaces(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": "endpoints: []"})
+	defer scenarios.ResetNamespace("test-admin-scenarios")
+	key := namespacedKey("test-admin-scenarios", "job")
+
+	if status, body := serveTest(server, http.MethodPut, "/__admin/scenarios/job?namespace=test-admin-scenarios", `{"state": "running"}`, nil); status != http.StatusNoContent {
+		t.Fatalf("put: got %d %s", status, body)
+	}
+	if state := scenarios.State(key); state != "running" {
+		t.Errorf("state is %s, want running", state)
+	}
+	// the full name addresses the same scenario
+	for _, url := range []string{"/__admin/scenarios/job?namespace=test-admin-scenarios", "/__admin/scenarios/" + key} {
+		if status, body := serveTest(server, http.MethodGet, url, "", nil); status != http.StatusOK || !strings.Contains(body, `"running"`) {
+			t.Errorf("get %s: got %d %s", url, status, body)
+		}
+	}
+	if _, body := serveTest(server, http.MethodGet, "/__admin/scenarios/job", "", nil); !strings.Contains(body, scenarioStarted) {
+		t.Errorf("scenario of the default namespace: got %s", body)
+	}
+
+	if status, _ := serveTest(server, http.MethodDelete, "/__admin/scenarios/job?namespace=test-admin-scenarios", "", nil); status != http.StatusNoContent {
+		t.Errorf("delete: got %d", status)
+	}
+	if state := scenarios.State(key); state != scenarioStarted {
+		t.Errorf("state is %s after the reset", state)
+	}
+
+	for _, url := range []string{
+		"/__admin/scenarios/job?namespace=a::b",
+		"/__admin/scenarios/job?namespace=a:",
+		"/__admin/scenarios/a::job?namespace=test-admin-scenarios",
+	} {
+		if status, _ := serveTest(server, http.MethodGet, url, "", nil); status != http.StatusBadRequest {
+			t.Errorf("get %s: got %d, want 400", url, status)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// dummyServer holds the endpoints served on one address: the endpoints of the
+// config file plus the endpoints registered at runtime via the admin API.
+type dummyServer struct {
+	name             string
+	lock             sync.Mutex
+	config           *ServerConfig
+	runtimeEndpoints []runtimeEndpoint
+	router           routerSwitch
+	admin            *httprouter.Router
+	tls              *serverTLS
+	grpc             *grpcListener
+	journal          *journal
+	httpServer       *http.Server
+	drain            *serverDrain
+}
+
+// serverDrain ends long-lived responses, i.e. event streams and websocket
+// connections, once the server shuts down. http.Server.Shutdown neither
+// cancels the contexts of requests nor tracks hijacked connections.
+type serverDrain struct {
+	once     sync.Once
+	draining chan struct{}
+	// hijacked connections, waited for by Shutdown
+	hijacked sync.WaitGroup
+}
+
+type serverDrainKey struct{}
+
+func (drain *serverDrain) start() {
+	drain.once.Do(func() { close(drain.draining) })
+}
+
+// drainingOf returns a channel closed once the server of request shuts down,
+// nil (blocking forever) outside of a server.
+func drainingOf(request *http.Request) <-chan struct{} {
+	if drain := drainOf(request); drain != nil {
+		return drain.draining
+	}
+	return nil
+}
+
+// stopOf returns a channel closed once the client of request goes away or its
+// server shuts down. release has to be called when the request is done.
+func stopOf(request *http.Request) (stop <-chan struct{}, release func()) {
+	draining := drainingOf(request)
+	stopped, released := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-request.Context().Done():
+		case <-draining:
+		case <-released:
+		}
+	}()
+	return stopped, func() { close(released) }
+}
+
+func drainOf(request *http.Request) *serverDrain {
+	drain, _ := request.Context().Value(serverDrainKey{}).(*serverDrain)
+	return drain
+}
+
+type runtimeEndpoint struct {
+	Id       string         `json:"id" yaml:"id"`
+	Endpoint EndpointStruct `json:"endpoint" yaml:"endpoint"`
+}
+
+func newDummyServer(cfg *ServerConfig) (*dummyServer, error) {
+	server := &dummyServer{
+		name:    cfg.Name,
+		journal: newJournal(0),
+		drain:   &serverDrain{draining: make(chan struct{})},
+	}
+	if err := server.apply(cfg, nil); err != nil {
+		return nil, err
+	}
+	if cfg.Tls.Enabled() {
+		var err error
+		if server.tls, err = newServerTLS(&cfg.Tls, cfg.Ip); err != nil {
+			return nil, err
+		}
+	}
+	server.admin = newAdminRouter(server)
+	return server, nil
+}
+
+func (server *dummyServer) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if strings.HasPrefix(request.URL.Path, adminPrefix) {
+		server.admin.ServeHTTP(response, request)
+		return
+	}
+	request = request.WithContext(context.WithValue(request.Context(), serverDrainKey{}, server.drain))
+	entry, recorder, request := journalRequest(response, request)
+	completed := false
+	defer func() {
+		if completed && recorder.status == 0 {
+			if err := request.Context().Err(); err != nil {
+				// the client went away before anything was written
+				if entry.Error == "" {
+					entry.Error = err.Error()
+				}
+			} else {
+				// nothing written, net/http responds with the default status
+				recorder.status = http.StatusOK
+			}
+		}
+		server.journal.finish(entry, recorder)
+	}()
+	server.router.ServeHTTP(recorder, request)
+	completed = true
+}
+
+// ListenAndServe binds to the configured address and serves requests until
+// the server is shut down, in which case http.ErrServerClosed is returned.
+func (server *dummyServer) ListenAndServe() error {
+	server.lock.Lock()
+	addr := fmt.Sprintf("%s:%d", server.config.Ip, server.config.Port)
+	server.httpServer = &http.Server{Addr: addr, Handler: server}
+	server.httpServer.RegisterOnShutdown(server.drain.start)
+	httpServer := server.httpServer
+	grpcAddr := ""
+	if server.grpc != nil {
+		grpcAddr = fmt.Sprintf("%s:%d", server.config.Ip, server.config.Grpc.Port)
+	}
+	server.lock.Unlock()
+
+	if grpcAddr != "" {
+		if err := server.grpc.listen(grpcAddr, server.tls); err != nil {
+			return err
+		}
+		log.Printf("Binding %s gRPC to: %s", server.name, grpcAddr)
+	}
+	if server.tls != nil {
+		log.Printf("Binding %s to: %s (TLS)", server.name, addr)
+		httpServer.TLSConfig = server.tls.config
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	log.Printf("Binding %s to: %s", server.name, addr)
+	return httpServer.ListenAndServe()
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to complete until ctx is done, after which all connections are closed.
+func (server *dummyServer) Shutdown(ctx context.Context) error {
+	server.lock.Lock()
+	httpServer := server.httpServer
+	server.lock.Unlock()
+	if httpServer == nil {
+		return nil
+	}
+	if server.grpc != nil {
+		defer server.grpc.shutdown(ctx)
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+		return err
+	}
+	// websocket connections are closing since the shutdown hook ran
+	closed := make(chan struct{})
+	go func() {
+		server.drain.hijacked.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// build creates the handler for the given config and runtime endpoints and
+// the services of the gRPC listener, if configured.
+func (server *dummyServer) build(cfg *ServerConfig, runtime []runtimeEndpoint) (http.Handler, *grpcServices, error) {
+	endpoints := make([]EndpointStruct, 0, len(cfg.Endpoints)+len(runtime))
+	endpoints = append(endpoints, cfg.Endpoints...)
+	for _, entry := range runtime {
+		endpoints = append(endpoints, entry.Endpoint)
+	}
+	var notFound http.Handler
+	if proxy := cfg.Proxy; proxy.Mode == proxyModeRecord {
+		var err error
+		if notFound, err = newRecordingProxy(proxy.Upstream, proxy.Recordings, proxy.BodyDirectory, proxy.MatchHeaders); err != nil {
+			return nil, nil, err
+		}
+	}
+	var services *grpcServices
+	if cfg.Grpc != nil {
+		var err error
+		if services, err = newGrpcServices(cfg.Grpc, cfg); err != nil {
+			return nil, nil, fmt.Errorf("grpc: %w", err)
+		}
+	}
+	handler, err := buildRouter(endpoints, notFound, cfg)
+	if err != nil || len(cfg.VirtualHosts) == 0 {
+		return handler, services, err
+	}
+
+	hosts := &virtualHosts{fallback: handler, hosts: make(map[string]http.Handler)}
+	for _, virtualHost := range cfg.VirtualHosts {
+		if len(virtualHost.Hosts) == 0 {
+			return nil, nil, fmt.Errorf("virtual host without hosts")
+		}
+		log.Printf("| Virtual host %s", strings.Join(virtualHost.Hosts, ", "))
+		if handler, err = buildRouter(virtualHost.Endpoints, notFound, cfg); err != nil {
+			return nil, nil, fmt.Errorf("virtual host %s: %w", virtualHost.Hosts[0], err)
+		}
+		for _, host := range virtualHost.Hosts {
+			host = strings.ToLower(host)
+			if _, exists := hosts.hosts[host]; exists {
+				return nil, nil, fmt.Errorf("duplicate virtual host '%s'", host)
+			}
+			hosts.hosts[host] = handler
+		}
+	}
+	return hosts, services, nil
+}
+
+// commit swaps in a handler created by build. The caller must hold
+// server.lock unless the server is not serving yet.
+func (server *dummyServer) commit(cfg *ServerConfig, runtime []runtimeEndpoint, handler http.Handler, services *grpcServices) {
+	server.router.Store(handler)
+	if services != nil {
+		if server.grpc == nil {
+			server.grpc = &grpcListener{journal: server.journal}
+		}
+		server.grpc.services.Store(services)
+	}
+	server.config = cfg
+	server.runtimeEndpoints = runtime
+	server.journal.SetLimit(cfg.Journal.Limit)
+}
+
+// apply builds a router for the given config and runtime endpoints and swaps
+// it in on success. The caller must hold server.lock unless the server is not
+// serving yet.
+func (server *dummyServer) apply(cfg *ServerConfig, runtime []runtimeEndpoint) error {
+	handler, services, err := server.build(cfg, runtime)
+	if err != nil {
+		return err
+	}
+	server.commit(cfg, runtime, handler, services)
+	return nil
+}
+
+// Config returns the currently served config.
+func (server *dummyServer) Config() *ServerConfig {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.config
+}
+
+// RuntimeEndpoints returns a copy of the endpoints registered at runtime.
+func (server *dummyServer) RuntimeEndpoints() []runtimeEndpoint {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return append([]runtimeEndpoint{}, server.runtimeEndpoints...)
+}
+
+// AddEndpoint registers a new runtime endpoint and returns its id.
+func (server *dummyServer) AddEndpoint(endpoint EndpointStruct) (string, error) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	id := uuid.Must(uuid.NewRandom()).String()
+	runtime := append(append([]runtimeEndpoint{}, server.runtimeEndpoints...), runtimeEndpoint{id, endpoint})
+	return id, server.apply(server.config, runtime)
+}
+
+// ReplaceEndpoint replaces the runtime endpoint identified by id.
+func (server *dummyServer) ReplaceEndpoint(id string, endpoint EndpointStruct) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	runtime := append([]runtimeEndpoint{}, server.runtimeEndpoints...)
+	for i := range runtime {
+		if runtime[i].Id == id {
+			runtime[i].Endpoint = endpoint
+			return server.apply(server.config, runtime)
+		}
+	}
+	return errEndpointNotFound
+}
+
+// DeleteEndpoint removes the runtime endpoint identified by id.
+func (server *dummyServer) DeleteEndpoint(id string) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	runtime := make([]runtimeEndpoint, 0, len(server.runtimeEndpoints))
+	for _, entry := range server.runtimeEndpoints {
+		if entry.Id != id {
+			runtime = append(runtime, entry)
+		}
+	}
+	if len(runtime) == len(server.runtimeEndpoints) {
+		return errEndpointNotFound
+	}
+	return server.apply(server.config, runtime)
+}
+
+// ClearEndpoints removes all runtime endpoints.
+func (server *dummyServer) ClearEndpoints() error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.apply(server.config, nil)
+}
+
+var errEndpointNotFound = errors.New("endpoint not found")
+
+// buildRouter creates a new router serving the given endpoints. Requests not
+// matching any endpoint are passed to notFound, if given. Setup errors of
+// endpoints and actions are returned instead of terminating the process.
+// The error handling and fault injection of the endpoints are taken from cfg.
+func buildRouter(endpoints []EndpointStruct, notFound http.Handler, cfg *ServerConfig) (handler http.Handler, buildErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buildErr = fmt.Errorf("%v", r)
+		}
+	}()
+
+	router := httprouter.New()
+	if notFound != nil {
+		router.NotFound = notFound
+		router.HandleMethodNotAllowed = false
+	}
+
+	// Create handlers, grouping variants of the same route.
+	type route struct{ method, url string }
+	routes := []route{}
+	variants := make(map[route][]routeVariant)
+	for i, endpoint := range endpoints {
+		log.Println(".")
+		if strings.HasPrefix(endpoint.Url, adminPrefix) {
+			log.Panicf("Endpoint url %s uses the reserved admin prefix %s", endpoint.Url, adminPrefix)
+		}
+		methods, err := endpoint.Method.Expand()
+		if err != nil {
+			log.Panicf("%v for %s", err, endpoint.Url)
+		}
+		var match *MatchStruct
+		if endpoint.Match != nil {
+			if match, err = endpoint.Match.compile(); err != nil {
+				log.Panicf("Invalid match for [%s] %s: %v", endpoint.Method, endpoint.Url, err)
+			}
+			if scenario := endpoint.Match.Scenario; scenario != nil && (scenario.Name == "" || scenario.State == "") {
+				log.Panicf("Invalid match for [%s] %s: scenario requires a name and a state", endpoint.Method, endpoint.Url)
+			}
+		}
+		handler := newEndpointHandler(endpoint, cfg)
+		wildcard := endpoint.Method.IsWildcard()
+		for _, method := range methods {
+			key := route{method, endpoint.Url}
+			for _, variant := range variants[key] {
+				if variant.match.specificity() == 0 && endpoint.Match.specificity() == 0 &&
+					variant.match.priority() == endpoint.Match.priority() && variant.wildcard == wildcard {
+					log.Panicf("Ambiguous endpoints [%s] %s, add a match block to tell them apart", method, endpoint.Url)
+				}
+				if variant.match.specificity() > 0 && variant.wildcard == wildcard &&
+					variant.match.normalized() == match.normalized() {
+					log.Panicf("Ambiguous endpoints [%s] %s with identical match blocks", method, endpoint.Url)
+				}
+			}
+			if _, exists := variants[key]; !exists {
+				routes = append(routes, key)
+			}
+			variants[key] = append(variants[key], routeVariant{match, endpoint.Namespace, wildcard, i, handler})
+		}
+		log.Printf(" `-> [%s] %s", endpoint.Method, endpoint.Url)
+	}
+	for _, key := range routes {
+		router.Handle(key.method, key.url, newRouteHandler(variants[key], notFound))
+	}
+	return router, nil
+}
+
+// virtualHosts dispatches requests by their host header.
+type virtualHosts struct {
+	hosts    map[string]http.Handler
+	fallback http.Handler
+}
+
+func (vh *virtualHosts) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	host := strings.ToLower(request.Host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if handler, exists := vh.hosts[host]; exists {
+		handler.ServeHTTP(response, request)
+		return
+	}
+	// wildcard subdomains, most specific first
+	for domain := host; strings.Contains(domain, "."); {
+		_, domain, _ = strings.Cut(domain, ".")
+		if handler, exists := vh.hosts["*."+domain]; exists {
+			handler.ServeHTTP(response, request)
+			return
+		}
+	}
+	vh.fallback.ServeHTTP(response, request)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeTestFiles writes files, given by their path relative to a new
+// temporary directory, and returns the directory.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// newTestServer loads the config.yaml of files and creates its first server.
+func newTestServer(t *testing.T, files map[string]string) *dummyServer {
+	t.Helper()
+	dir := writeTestFiles(t, files)
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newDummyServer(&cfg.Servers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// serveTest sends a request to server and returns status and body of the
+// response.
+func serveTest(server *dummyServer, method string, url string, body string, headers map[string]string) (int, string) {
+	request := httptest.NewRequest(method, url, strings.NewReader(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	data, _ := io.ReadAll(recorder.Result().Body)
+	return recorder.Code, string(data)
+}
+
+func TestMatchSelectsVariant(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /items
+    method: POST
+    actions:
+      - type: response
+        params: {body: default}
+  - url: /items
+    method: POST
+    match:
+      query: {mode: fast}
+    actions:
+      - type: response
+        params: {body: query}
+  - url: /items
+    method: POST
+    match:
+      headers:
+        X-Role: {regex: "^admin"}
+    actions:
+      - type: response
+        params: {body: header}
+  - url: /items
+    method: POST
+    match:
+      body:
+        user.roles[0]: owner
+    actions:
+      - type: response
+        params: {body: body}
+  - url: /items
+    method: POST
+    match:
+      priority: 10
+      query: {mode: {absent: true}}
+      headers:
+        X-Priority: "yes"
+    actions:
+      - type: response
+        params: {body: priority}
+`})
+
+	tests := []struct {
+		name    string
+		url     string
+		body    string
+		headers map[string]string
+		want    string
+	}{
+		{"no predicate matches", "/items", "", nil, "default"},
+		{"query", "/items?mode=fast", "", nil, "query"},
+		{"header regex", "/items", "", map[string]string{"X-Role": "admin-1"}, "header"},
+		{"header regex mismatch", "/items", "", map[string]string{"X-Role": "user"}, "default"},
+		{"json body path", "/items", `{"user": {"roles": ["owner"]}}`, nil, "body"},
+		{"priority before specificity", "/items", `{"user": {"roles": ["owner"]}}`, map[string]string{"X-Priority": "yes"}, "priority"},
+		{"absent query", "/items?mode=slow", "", map[string]string{"X-Priority": "yes"}, "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := serveTest(server, http.MethodPost, test.url, test.body, test.headers)
+			if status != http.StatusOK || body != test.want {
+				t.Errorf("got %d %q, want 200 %q", status, body, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchScenario(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /order
+    method: GET
+    actions:
+      - type: scenario
+        params: {name: test-match-order, state: shipped}
+      - type: response
+        params: {body: created}
+  - url: /order
+    method: GET
+    match:
+      scenario: {name: test-match-order, state: shipped}
+    actions:
+      - type: response
+        params: {body: shipped}
+`})
+	defer scenarios.Reset("test-match-order")
+
+	for _, want := range []string{"created", "shipped", "shipped"} {
+		if _, body := serveTest(server, http.MethodGet, "/order", "", nil); body != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+	}
+}
+
+func TestUnmatchedRequest(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /only
+    method: GET
+    match:
+      query: {id: "1"}
+    actions:
+      - type: response
+        params: {body: one}
+`})
+
+	if status, _ := serveTest(server, http.MethodGet, "/only?id=2", "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", status)
+	}
+}
+
+func TestMatchWhileEndpointsChange(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /items
+    method: GET
+    match:
+      query: {a: x, b: {regex: "^y"}}
+    actions:
+      - type: response
+        params: {body: matched}
+`})
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, body := serveTest(server, http.MethodGet, "/items?a=x&b=y", "", nil); body != "matched" {
+					t.Errorf("got %q, want matched", body)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := server.AddEndpoint(EndpointStruct{Url: "/other", Method: Methods{http.MethodGet}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.ClearEndpoints(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wait.Wait()
+}
+
+func TestVirtualHosts(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": `
+servers:
+  - port: 8080
+    endpoints:
+      - url: /orders
+        method: GET
+        actions:
+          - type: response
+            params: {body: default}
+      - url: /status
+        method: GET
+        actions:
+          - type: response
+            params: {body: up}
+    virtualHosts:
+      - hosts: [Orders.local, "*.orders.local"]
+        endpoints:
+          - url: /orders
+            method: GET
+            actions:
+              - type: response
+                params: {body: orders}
+      - hosts: [eu.orders.local]
+        endpoints:
+          - url: /orders
+            method: GET
+            actions:
+              - type: response
+                params: {body: eu}
+`})
+
+	tests := []struct {
+		host string
+		url  string
+		want string
+	}{
+		{"orders.local", "/orders", "200 orders"},
+		{"ORDERS.LOCAL:8080", "/orders", "200 orders"},
+		{"a.b.orders.local", "/orders", "200 orders"},
+		{"eu.orders.local", "/orders", "200 eu"},
+		{"x.eu.orders.local", "/orders", "200 orders"},
+		{"other.local", "/orders", "200 default"},
+		{"127.0.0.1:8080", "/orders", "200 default"},
+		// the endpoints of the server are not served for virtual hosts
+		{"orders.local", "/status", "404 "},
+	}
+	for _, test := range tests {
+		t.Run(test.host+test.url, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.url, nil)
+			request.Host = test.host
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+			if got := fmt.Sprintf("%d %s", recorder.Code, recorder.Body.String()); !strings.HasPrefix(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateVirtualHosts(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": `
+servers:
+  - port: 8080
+    endpoints: []
+    virtualHosts:
+      - hosts: [a.local]
+        endpoints: []
+      - hosts: [A.local]
+        endpoints: []
+`})
+	cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDummyServer(&cfg.Servers[0]); err == nil || !strings.Contains(err.Error(), "duplicate virtual host 'a.local'") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestAmbiguousEndpoints(t *testing.T) {
+	const adminMatch = "{query: {id: '1'}, headers: {X-Role: admin}}"
+	tests := []struct {
+		name   string
+		method string
+		first  string
+		second string
+		err    string
+	}{
+		{"without match blocks", "GET", "{}", "{}", "Ambiguous endpoints [GET] /items, add a match block"},
+		{"identical match blocks", "GET", adminMatch, "{headers: {x-role: {equals: admin}}, query: {id: '1'}}", "Ambiguous endpoints [GET] /items with identical match blocks"},
+		{"different matchers", "GET", adminMatch, "{headers: {X-Role: {regex: admin}}, query: {id: '1'}}", ""},
+		{"different priorities", "GET", adminMatch, "{priority: 1, headers: {X-Role: admin}, query: {id: '1'}}", ""},
+		{"registered via ANY", "ANY", adminMatch, adminMatch, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeTestFiles(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /items
+    method: GET
+    match: ` + test.first + `
+    actions:
+      - type: response
+        params: {body: first}
+  - url: /items
+    method: ` + test.method + `
+    match: ` + test.second + `
+    actions:
+      - type: response
+        params: {body: second}
+`})
+			cfg, err := loadConfig(filepath.Join(dir, "config.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = newDummyServer(&cfg.Servers[0])
+			if test.err == "" && err != nil {
+				t.Errorf("got error %v", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
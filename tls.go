@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+type TLSConfig struct {
+	// certificate and key files (PEM) to serve
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// generate a CA and a leaf certificate signed by it on startup instead
+	SelfSigned bool `yaml:"selfSigned"`
+	// host names and ips of the generated leaf certificate
+	Hosts []string
+}
+
+func (cfg *TLSConfig) Enabled() bool {
+	return cfg.SelfSigned || cfg.CertFile != ""
+}
+
+// serverTLS is the TLS setup of a server. The CA certificate is only available
+// for generated certificates.
+type serverTLS struct {
+	config        *tls.Config
+	caCertificate *x509.Certificate
+}
+
+func newServerTLS(cfg *TLSConfig, ip string) (*serverTLS, error) {
+	if !cfg.SelfSigned {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		return &serverTLS{config: &tls.Config{Certificates: []tls.Certificate{certificate}}}, nil
+	}
+
+	hosts := cfg.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if ip != "" && ip != "127.0.0.1" && ip != "0.0.0.0" {
+			hosts = append(hosts, ip)
+		}
+	}
+	certificate, caCertificate, err := generateCertificates(hosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tls certificates: %w", err)
+	}
+	return &serverTLS{
+		config:        &tls.Config{Certificates: []tls.Certificate{certificate}},
+		caCertificate: caCertificate,
+	}, nil
+}
+
+// generateCertificates creates a new CA and a leaf certificate for hosts
+// signed by it. Both only live in memory.
+func generateCertificates(hosts []string) (tls.Certificate, *x509.Certificate, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerialNumber(),
+		Subject:               pkix.Name{Organization: []string{"DummyServer"}, CommonName: "DummyServer CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caCertificate, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject:      pkix.Name{Organization: []string{"DummyServer"}, CommonName: hosts[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			leafTemplate.IPAddresses = append(leafTemplate.IPAddresses, ip)
+		} else {
+			leafTemplate.DNSNames = append(leafTemplate.DNSNames, host)
+		}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCertificate, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{leafDER, caDER},
+		PrivateKey:  leafKey,
+	}, caCertificate, nil
+}
+
+func randomSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTLSConfig = `
+server:
+  tls:
+    selfSigned: true
+    hosts: [localhost, 127.0.0.1]
+  endpoints:
+    - url: /hello
+      method: GET
+      actions:
+        - type: response
+          params: {body: hello}
+`
+
+// startTestTLS serves server with its TLS config and returns a client which
+// only trusts roots.
+func startTestTLS(t *testing.T, server *dummyServer, roots *x509.CertPool) (*httptest.Server, *http.Client) {
+	t.Helper()
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.TLS = server.tls.config
+	httpServer.StartTLS()
+	t.Cleanup(httpServer.Close)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	return httpServer, client
+}
+
+func TestSelfSignedCertificates(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testTLSConfig})
+	ca := server.tls.caCertificate
+	if ca == nil || !ca.IsCA {
+		t.Fatalf("got CA certificate %v", ca)
+	}
+	leaf, err := x509.ParseCertificate(server.tls.config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err == nil {
+		t.Error("leaf certificate is valid for a host it was not generated for")
+	}
+
+	// clients trusting the CA connect without further setup
+	httpServer, client := startTestTLS(t, server, roots)
+	response, err := client.Get(httpServer.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if body, _ := io.ReadAll(response.Body); string(body) != "hello" {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestAdminExportsCA(t *testing.T) {
+	server := newTestServer(t, map[string]string{"config.yaml": testTLSConfig})
+	raw := string(server.tls.caCertificate.Raw)
+
+	status, body := serveTest(server, http.MethodGet, "/__admin/tls/ca.pem", "", nil)
+	if block, _ := pem.Decode([]byte(body)); status != http.StatusOK || block == nil || string(block.Bytes) != raw {
+		t.Errorf("ca.pem: got %d %q", status, body)
+	}
+	for _, file := range []string{"ca.der", "ca.crt"} {
+		if status, body := serveTest(server, http.MethodGet, "/__admin/tls/"+file, "", nil); status != http.StatusOK || body != raw {
+			t.Errorf("%s: got %d and %d bytes", file, status, len(body))
+		}
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/__admin/tls/ca.key", "", nil); status != http.StatusNotFound {
+		t.Errorf("ca.key: got %d, want 404", status)
+	}
+
+	// there is no CA without generated certificates
+	plain := newTestServer(t, map[string]string{"config.yaml": "endpoints: []"})
+	if status, _ := serveTest(plain, http.MethodGet, "/__admin/tls/ca.pem", "", nil); status != http.StatusNotFound {
+		t.Errorf("without tls: got %d, want 404", status)
+	}
+}
+
+func TestCertificateFiles(t *testing.T) {
+	certificate, ca, err := generateCertificates([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, map[string]string{
+		"config.yaml": `
+server:
+  tls:
+    certFile: cert.pem
+    keyFile: key.pem
+  endpoints: []
+`,
+		"cert.pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})),
+		"key.pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+	})
+	if server.tls.caCertificate != nil {
+		t.Error("got a CA certificate for configured certificate files")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	httpServer, client := startTestTLS(t, server, roots)
+	if response, err := client.Get(httpServer.URL + "/missing"); err != nil {
+		t.Fatal(err)
+	} else {
+		response.Body.Close()
+	}
+}
+
+func TestReloadKeepsCertificates(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"config.yaml": testTLSConfig})
+	path := filepath.Join(dir, "config.yaml")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newDummyServer(&cfg.Servers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsSetup := server.tls
+
+	if err := os.WriteFile(path, []byte(`
+server:
+  tls:
+    selfSigned: true
+    hosts: [example.com]
+  endpoints: []
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadServers([]*dummyServer{server}, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	// the generated certificates stay valid for the clients trusting the CA
+	if server.tls != tlsSetup || len(server.config.Tls.Hosts) != 2 {
+		t.Errorf("certificates were replaced on reload, hosts %v", server.config.Tls.Hosts)
+	}
+	if status, _ := serveTest(server, http.MethodGet, "/hello", "", nil); status != http.StatusNotFound {
+		t.Errorf("endpoints were not reloaded, got %d", status)
+	}
+}
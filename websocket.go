@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// WebsocketStruct turns an endpoint into a WebSocket endpoint. The actions of
+// the endpoint run before the upgrade, so they may still reject it with a
+// response. Every event runs its actions with a copy of the context of the
+// connection, messages are sent by writing to the response.
+type WebsocketStruct struct {
+	// Subprotocols offered to clients, in order of preference
+	Subprotocols []string `json:"subprotocols,omitempty" yaml:",omitempty"`
+	// Actions run once the connection is established
+	OnConnect []ActionStruct `json:"onConnect,omitempty" yaml:"onConnect,omitempty"`
+	// Handlers of incoming messages, only the first matching one is run
+	OnMessage []WebsocketMessageStruct `json:"onMessage,omitempty" yaml:"onMessage,omitempty"`
+	// Actions run periodically while the connection is open
+	Periodic []WebsocketPeriodicStruct `json:"periodic,omitempty" yaml:",omitempty"`
+}
+
+type WebsocketMessageStruct struct {
+	// Body matchers apply to the message, all others to the upgrade request
+	Match   *MatchStruct   `json:"match,omitempty" yaml:",omitempty"`
+	Actions []ActionStruct `json:"actions"`
+}
+
+type WebsocketPeriodicStruct struct {
+	// Milliseconds between two runs, the first run is after one interval
+	Interval int `json:"interval"`
+	// Number of runs, 0 runs until the connection is closed
+	Count   int            `json:"count,omitempty" yaml:",omitempty"`
+	Actions []ActionStruct `json:"actions"`
+}
+
+const websocketWriteTimeout = 10 * time.Second
+
+type websocketMessageHandler struct {
+	match    *MatchStruct
+	actions  []ActionStruct
+	handlers []ActionHandler
+}
+
+type websocketPeriodicHandler struct {
+	interval time.Duration
+	count    int
+	actions  []ActionStruct
+	handlers []ActionHandler
+}
+
+// websocketEndpoint serves the connections of one endpoint, which are
+// registered for broadcasts.
+type websocketEndpoint struct {
+	endpoint  EndpointStruct
+	key       websocketEndpointKey
+	upgrader  websocket.Upgrader
+	onConnect []ActionHandler
+	onMessage []websocketMessageHandler
+	periodic  []websocketPeriodicHandler
+	onError   []ActionHandler
+}
+
+// websocketEndpointKey identifies an endpoint across rebuilds of the router,
+// e.g. by reloads or admin changes, so that broadcasts reach the connections
+// established before.
+type websocketEndpointKey struct {
+	server, url, name string
+}
+
+// websocketSessions holds the connections of all websocket endpoints.
+var websocketSessions = struct {
+	lock     sync.Mutex
+	sessions map[websocketEndpointKey]map[*websocketSession]struct{}
+}{sessions: make(map[websocketEndpointKey]map[*websocketSession]struct{})}
+
+func newWebsocketEndpoint(endpoint EndpointStruct, server string, onError []ActionHandler) *websocketEndpoint {
+	if methods, err := endpoint.Method.Expand(); err != nil || len(methods) != 1 || methods[0] != http.MethodGet {
+		log.Panicf("WebSocket endpoint %s must use method GET", endpoint.Url)
+	}
+	config := endpoint.Websocket
+	ws := &websocketEndpoint{
+		endpoint: endpoint,
+		key:      websocketEndpointKey{server, endpoint.Url, endpoint.Name},
+		upgrader: websocket.Upgrader{
+			Subprotocols: config.Subprotocols,
+			// a mock has to accept clients of any origin
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		onConnect: createActionHandlers(endpoint, config.OnConnect),
+		onError:   onError,
+	}
+	for i, message := range config.OnMessage {
+		var match *MatchStruct
+		if message.Match != nil {
+			var err error
+			if match, err = message.Match.compile(); err != nil {
+				log.Panicf("Invalid match of websocket message handler #%d for %s: %v", i, endpoint.Url, err)
+			}
+		}
+		ws.onMessage = append(ws.onMessage, websocketMessageHandler{
+			match:    match,
+			actions:  message.Actions,
+			handlers: createActionHandlers(endpoint, message.Actions),
+		})
+	}
+	for i, periodic := range config.Periodic {
+		if periodic.Interval <= 0 {
+			log.Panicf("Periodic websocket actions #%d for %s require a positive interval", i, endpoint.Url)
+		}
+		ws.periodic = append(ws.periodic, websocketPeriodicHandler{
+			interval: time.Duration(periodic.Interval) * time.Millisecond,
+			count:    periodic.Count,
+			actions:  periodic.Actions,
+			handlers: createActionHandlers(endpoint, periodic.Actions),
+		})
+	}
+	log.Printf("| {websocket=%d message handlers, %d periodic}", len(ws.onMessage), len(ws.periodic))
+	return ws
+}
+
+// serve upgrades the request and handles the connection until it is closed.
+func (ws *websocketEndpoint) serve(
+	requestId string,
+	response http.ResponseWriter,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) {
+	conn, err := ws.upgrader.Upgrade(response, request, nil)
+	if err != nil {
+		// the upgrader already responded with an error
+		log.Printf("[%s] websocket upgrade failed: %v", requestId, err)
+		return
+	}
+	journalStatus(response, http.StatusSwitchingProtocols)
+	session := &websocketSession{id: requestId, conn: conn, endpoint: ws}
+	// hijacked connections are not closed by the shutdown of the server, which
+	// waits for them until the close frame is written
+	drain := drainOf(request)
+	if drain != nil {
+		drain.hijacked.Add(1)
+		defer drain.hijacked.Done()
+	}
+	ws.register(session)
+	defer ws.unregister(session)
+	defer session.close(websocket.CloseNormalClosure, "")
+	log.Printf("[%s] websocket connected", requestId)
+
+	if drain != nil {
+		closed := make(chan struct{})
+		defer close(closed)
+		go func() {
+			select {
+			case <-drain.draining:
+				session.close(websocket.CloseGoingAway, "server shutting down")
+			case <-closed:
+			}
+		}()
+	}
+
+	context["__connection__"] = requestId
+	ws.run(session, ws.onConnect, ws.endpoint.Websocket.OnConnect, request, params, context)
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, periodic := range ws.periodic {
+		wg.Add(1)
+		go func(periodic websocketPeriodicHandler) {
+			defer wg.Done()
+			ticker := time.NewTicker(periodic.interval)
+			defer ticker.Stop()
+			for tick := 1; periodic.count <= 0 || tick <= periodic.count; tick++ {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				eventContext := copyContext(context)
+				eventContext["__tick__"] = tick
+				ws.run(session, periodic.handlers, periodic.actions, request, params, eventContext)
+			}
+		}(periodic)
+	}
+	defer wg.Wait()
+	defer close(done)
+
+	for !session.closed.Load() {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !session.closed.Load() {
+				log.Printf("[%s] websocket closed: %v", requestId, err)
+			}
+			return
+		}
+		ws.handleMessage(session, messageType, data, request, params, context)
+	}
+}
+
+// handleMessage runs the first message handler matching data. The message is
+// also the body of the request seen by the actions.
+func (ws *websocketEndpoint) handleMessage(
+	session *websocketSession,
+	messageType int,
+	data []byte,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) {
+	messageRequest := request.Clone(request.Context())
+	messageRequest.Body = io.NopCloser(bytes.NewReader(data))
+	messageRequest.ContentLength = int64(len(data))
+	mr := &matchRequest{request: messageRequest, params: params, body: data, bodyRead: true}
+	for _, handler := range ws.onMessage {
+		if !handler.match.matches(mr, ws.endpoint.Namespace) {
+			continue
+		}
+		message := map[string]interface{}{
+			"text":   string(data),
+			"binary": messageType == websocket.BinaryMessage,
+		}
+		if value, err := mr.readJSON(); err == nil {
+			message["json"] = value
+		}
+		eventContext := copyContext(context)
+		eventContext["__message__"] = message
+		ws.run(session, handler.handlers, handler.actions, messageRequest, params, eventContext)
+		return
+	}
+	log.Printf("[%s] websocket message without matching handler: %.100s", session.id, data)
+}
+
+// run executes the actions of an event. Failures are answered by the onError
+// actions of the endpoint, if any, and do not close the connection.
+func (ws *websocketEndpoint) run(
+	session *websocketSession,
+	handlers []ActionHandler,
+	actions []ActionStruct,
+	request *http.Request,
+	params httprouter.Params,
+	context map[string]interface{},
+) {
+	var (
+		writer = &websocketWriter{session: session, header: make(http.Header)}
+		global = newGlobalSnapshot(context["__namespace__"].(string))
+		action string
+	)
+	defer func() {
+		if r := recover(); r != nil {
+			failure := actionFailureOf(r, session.id, ws.endpoint, action)
+			if len(ws.onError) > 0 && !session.closed.Load() {
+				context["__error__"] = map[string]interface{}{
+					"requestId": failure.RequestId,
+					"action":    failure.Action,
+					"message":   failure.Message,
+					"errors":    failure.Errors,
+				}
+				runErrorHandlers(session.id, ws.onError, writer, request, params, context)
+			}
+		}
+	}()
+	for i, handler := range handlers {
+		if session.closed.Load() {
+			return
+		}
+		action = actions[i].Type
+		context["__global__"] = global.get()
+		handler(session.id, writer, request, params, context)
+	}
+}
+
+func (ws *websocketEndpoint) register(session *websocketSession) {
+	websocketSessions.lock.Lock()
+	defer websocketSessions.lock.Unlock()
+	sessions, exists := websocketSessions.sessions[ws.key]
+	if !exists {
+		sessions = make(map[*websocketSession]struct{})
+		websocketSessions.sessions[ws.key] = sessions
+	}
+	sessions[session] = struct{}{}
+}
+
+func (ws *websocketEndpoint) unregister(session *websocketSession) {
+	websocketSessions.lock.Lock()
+	defer websocketSessions.lock.Unlock()
+	sessions := websocketSessions.sessions[ws.key]
+	delete(sessions, session)
+	if len(sessions) == 0 {
+		delete(websocketSessions.sessions, ws.key)
+	}
+}
+
+// broadcast sends a message to all connections of the endpoint, including
+// those established before the last rebuild of the router, and returns the
+// number of connections reached.
+func (ws *websocketEndpoint) broadcast(messageType int, data []byte) int {
+	websocketSessions.lock.Lock()
+	sessions := make([]*websocketSession, 0, len(websocketSessions.sessions[ws.key]))
+	for session := range websocketSessions.sessions[ws.key] {
+		sessions = append(sessions, session)
+	}
+	websocketSessions.lock.Unlock()
+	sent := 0
+	for _, session := range sessions {
+		if session.send(messageType, data) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// websocketSession is an established connection. Messages may be sent
+// concurrently by the events of the connection and by broadcasts.
+type websocketSession struct {
+	id        string
+	conn      *websocket.Conn
+	endpoint  *websocketEndpoint
+	writeLock sync.Mutex
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+func (session *websocketSession) send(messageType int, data []byte) error {
+	if session.closed.Load() {
+		return websocket.ErrCloseSent
+	}
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	return session.conn.WriteMessage(messageType, data)
+}
+
+// close sends a close frame with code and reason and closes the connection.
+func (session *websocketSession) close(code int, reason string) {
+	// concurrent calls return once the close frame is written
+	session.closeOnce.Do(func() {
+		session.closed.Store(true)
+		session.writeLock.Lock()
+		defer session.writeLock.Unlock()
+		err := session.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(websocketWriteTimeout))
+		session.conn.Close()
+		if err == nil {
+			log.Printf("[%s] websocket closed with code %d", session.id, code)
+		}
+	})
+}
+
+// websocketWriter is the response of the actions of a websocket event, every
+// write is sent as a text message.
+type websocketWriter struct {
+	session *websocketSession
+	header  http.Header
+}
+
+func (writer *websocketWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *websocketWriter) WriteHeader(status int) {}
+
+func (writer *websocketWriter) Write(data []byte) (int, error) {
+	if err := writer.session.send(websocket.TextMessage, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// copyContext returns a deep copy of context, so that the events of a
+// connection, which may run concurrently, do not interfere with each other.
+func copyContext(context map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(context)+1)
+	for key, value := range context {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+// copyValue deep copies the maps and slices of a context value.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return copyContext(value)
+	case map[string]string:
+		copied := make(map[string]string, len(value))
+		for key, item := range value {
+			copied[key] = item
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
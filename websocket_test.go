@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testWebsocketConfig = `
+endpoints:
+  - url: /chat/:room
+    method: GET
+    websocket:
+      onConnect:
+        - type: ws-send
+          params: {message: 'welcome to {{.params.room}}'}
+      onMessage:
+        - match:
+            body:
+              type: ping
+          actions:
+            - type: ws-send
+              params: {message: 'pong {{.__message__.json.id}}'}
+        - match:
+            body:
+              $: {contains: '@all'}
+          actions:
+            - type: ws-send
+              params: {message: '{{.__message__.text}}', broadcast: true}
+        - actions:
+            - type: ws-close
+              params: {code: 4000, reason: unsupported message}
+`
+
+// dialTestWebsocket connects to url of server and reads the welcome message.
+func dialTestWebsocket(t *testing.T, server *httptest.Server, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	readTestMessage(t, conn)
+	return conn
+}
+
+func readTestMessage(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeTestMessage(t *testing.T, conn *websocket.Conn, message string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebsocketMessages(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": testWebsocketConfig}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/chat/lobby", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if message := readTestMessage(t, conn); message != "welcome to lobby" {
+		t.Errorf("got %q on connect", message)
+	}
+	writeTestMessage(t, conn, `{"type": "ping", "id": 7}`)
+	if message := readTestMessage(t, conn); message != "pong 7" {
+		t.Errorf("got %q for a ping", message)
+	}
+
+	// unmatched messages are answered by the last handler
+	writeTestMessage(t, conn, "something else")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	closeErr := &websocket.CloseError{}
+	if !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Text != "unsupported message" {
+		t.Errorf("got %v, want close 4000", err)
+	}
+}
+
+func TestWebsocketStructuredMessage(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /echo/:room
+    method: GET
+    websocket:
+      onConnect:
+        - type: ws-send
+          params: {message: hello}
+      onMessage:
+        - actions:
+            - type: ws-send
+              params:
+                message:
+                  room: '{{index .params "room"}}'
+                  text: '{{.__message__.json.text}}'
+                  count: 2
+`}))
+	defer server.Close()
+
+	// substituted values with quotes and newlines are escaped in the JSON
+	conn := dialTestWebsocket(t, server, "/echo/lobby")
+	writeTestMessage(t, conn, `{"text": "say \"hi\"\nbye"}`)
+	var message map[string]interface{}
+	if err := json.Unmarshal([]byte(readTestMessage(t, conn)), &message); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"room": "lobby", "text": "say \"hi\"\nbye", "count": float64(2)}
+	if !reflect.DeepEqual(message, want) {
+		t.Errorf("got %v, want %v", message, want)
+	}
+}
+
+func TestWebsocketPeriodic(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /ticks
+    method: GET
+    websocket:
+      onConnect:
+        - type: ws-send
+          params: {message: hello}
+      periodic:
+        - interval: 10
+          count: 3
+          actions:
+            - type: ws-send
+              params: {message: 'tick {{.__tick__}}'}
+`}))
+	defer server.Close()
+
+	conn := dialTestWebsocket(t, server, "/ticks")
+	for _, want := range []string{"tick 1", "tick 2", "tick 3"} {
+		if message := readTestMessage(t, conn); message != want {
+			t.Errorf("got %q, want %q", message, want)
+		}
+	}
+}
+
+func TestWebsocketEventContextsAreIsolated(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t, map[string]string{"config.yaml": `
+endpoints:
+  - url: /state
+    method: GET
+    websocket:
+      onConnect:
+        - type: cache-get
+          params: {mapping: {ws-missing: {path: state.seed, default: 0}}}
+        - type: ws-send
+          params: {message: hello}
+      periodic:
+        - interval: 1
+          actions:
+            - type: cache-get
+              params: {mapping: {ws-missing: {path: state.tick, default: 1}}}
+      onMessage:
+        - actions:
+            - type: cache-get
+              params: {mapping: {ws-missing: {path: state.message, default: 1}}}
+            - type: ws-send
+              params: {message: 'seed {{.state.seed}} tick {{.state.tick}}'}
+`}))
+	defer server.Close()
+
+	// the nested state of the connection is copied for every event
+	conn := dialTestWebsocket(t, server, "/state")
+	for i := 0; i < 20; i++ {
+		writeTestMessage(t, conn, "next")
+		if message := readTestMessage(t, conn); message != "seed 0 tick <no value>" {
+			t.Fatalf("got %q", message)
+		}
+	}
+}
+
+func TestWebsocketBroadcastAcrossRebuilds(t *testing.T) {
+	dummy := newTestServer(t, map[string]string{"config.yaml": testWebsocketConfig})
+	server := httptest.NewServer(dummy)
+	defer server.Close()
+
+	before := dialTestWebsocket(t, server, "/chat/a")
+	// a runtime endpoint rebuilds the router, and with it the endpoint
+	if _, err := dummy.AddEndpoint(EndpointStruct{Url: "/other", Method: Methods{http.MethodGet}}); err != nil {
+		t.Fatal(err)
+	}
+	after := dialTestWebsocket(t, server, "/chat/b")
+
+	writeTestMessage(t, after, "hi @all")
+	for name, conn := range map[string]*websocket.Conn{"before": before, "after": after} {
+		if message := readTestMessage(t, conn); message != "hi @all" {
+			t.Errorf("connection %s got %q", name, message)
+		}
+	}
+	writeTestMessage(t, before, "bye @all")
+	if message := readTestMessage(t, after); message != "bye @all" {
+		t.Errorf("got %q from the connection established before the rebuild", message)
+	}
+}
+
+func TestWebsocketCloseOnShutdown(t *testing.T) {
+	dummy := newTestServer(t, map[string]string{"config.yaml": testWebsocketConfig})
+	server := httptest.NewUnstartedServer(dummy)
+	server.Config.RegisterOnShutdown(dummy.drain.start)
+	server.Start()
+	defer server.Close()
+
+	conn := dialTestWebsocket(t, server, "/chat/a")
+	go server.Config.Shutdown(context.Background())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	closeErr := &websocket.CloseError{}
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("got %v, want close 1001", err)
+	}
+}